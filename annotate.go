@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+const (
+	annotateGrafana = "grafana"
+	annotateWebhook = "webhook"
+)
+
+// annotator marks the start and end of runs on dashboards: as a region
+// annotation through the Grafana HTTP API, or as two JSON events posted to
+// a webhook.
+type annotator struct {
+	URL    string
+	Format string
+	Token  string
+	Tags   []string
+	client *http.Client
+}
+
+func newAnnotator(url, format, token string, tags []string) (*annotator, error) {
+	if format != annotateGrafana && format != annotateWebhook {
+		return nil, fmt.Errorf("unknown annotation format %q: use %s or %s", format, annotateGrafana, annotateWebhook)
+	}
+	return &annotator{
+		URL:    strings.TrimRight(url, "/"),
+		Format: format,
+		Token:  token,
+		Tags:   tags,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// annotation is a run being annotated.
+type annotation struct {
+	id          int64
+	start       time.Time
+	command     string
+	target      string
+	fromVersion int
+}
+
+type annotationEvent struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	Target      string    `json:"target"`
+	FromVersion int       `json:"from_version"`
+	ToVersion   *int      `json:"to_version,omitempty"`
+	Applied     *int      `json:"applied,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Traceparent string    `json:"traceparent,omitempty"`
+}
+
+type grafanaAnnotation struct {
+	Time    int64    `json:"time,omitempty"`
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Text    string   `json:"text"`
+}
+
+func (a *annotator) started(ctx context.Context, an *annotation, traceparent string) error {
+	if a.Format == annotateWebhook {
+		return a.send(ctx, http.MethodPost, a.URL, annotationEvent{
+			Event:       "run-started",
+			Time:        an.start.UTC(),
+			Command:     an.command,
+			Target:      an.target,
+			FromVersion: an.fromVersion,
+			Traceparent: traceparent,
+		}, nil)
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := a.send(ctx, http.MethodPost, a.URL+"/api/annotations", grafanaAnnotation{
+		Time: an.start.UnixMilli(),
+		Tags: append([]string{"migrate", an.command}, a.Tags...),
+		Text: fmt.Sprintf("migrate %s on %s started at version %s", an.command, an.target, formatVersion(an.fromVersion, false)),
+	}, &created)
+	an.id = created.ID
+	return err
+}
+
+func (a *annotator) finished(ctx context.Context, an *annotation, toVersion int, res *runResult, runErr error, traceparent string) error {
+	applied := len(res.Applied)
+	if a.Format == annotateWebhook {
+		return a.send(ctx, http.MethodPost, a.URL, annotationEvent{
+			Event:       "run-finished",
+			Time:        time.Now().UTC(),
+			Command:     an.command,
+			Target:      an.target,
+			FromVersion: an.fromVersion,
+			ToVersion:   &toVersion,
+			Applied:     &applied,
+			Status:      eventStatus(runErr),
+			Error:       eventError(runErr),
+			Traceparent: traceparent,
+		}, nil)
+	}
+
+	if an.id == 0 {
+		return errors.New("the start annotation was not created")
+	}
+	url := fmt.Sprintf("%s/api/annotations/%d", a.URL, an.id)
+	if applied == 0 && runErr == nil {
+		// Nothing changed: do not leave a mark on the dashboards.
+		return a.send(ctx, http.MethodDelete, url, nil, nil)
+	}
+	text := fmt.Sprintf("migrate %s on %s: version %s -> %s, %d migration(s)",
+		an.command, an.target, formatVersion(an.fromVersion, false), formatVersion(toVersion, false), applied)
+	if runErr != nil {
+		text += ", failed: " + runErr.Error()
+	}
+	return a.send(ctx, http.MethodPatch, url, grafanaAnnotation{
+		TimeEnd: time.Now().UnixMilli(),
+		Text:    text,
+	}, nil)
+}
+
+func (a *annotator) send(ctx context.Context, method, url string, body, reply any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if reply != nil {
+		return json.NewDecoder(resp.Body).Decode(reply)
+	}
+	return nil
+}
+
+// currentVersion returns the applied version, or database.NilVersion.
+func (r *runner) currentVersion() int {
+	version, _, err := r.m.Version()
+	if err != nil {
+		return database.NilVersion
+	}
+	return int(version)
+}
+
+func (r *runner) annotateStarted(ctx context.Context, command string, res *runResult) *annotation {
+	if r.opts.Annotations == nil {
+		return nil
+	}
+	target := r.progress.target
+	if target == "" {
+		target = r.schema
+	}
+	an := &annotation{start: time.Now(), command: command, target: target, fromVersion: r.currentVersion()}
+	if err := r.opts.Annotations.started(ctx, an, r.opts.Traceparent); err != nil {
+		res.warn("failed to publish run annotation: %v", err)
+	}
+	return an
+}
+
+func (r *runner) annotateFinished(ctx context.Context, an *annotation, res *runResult, err error) {
+	if an == nil {
+		return
+	}
+	if err := r.opts.Annotations.finished(context.WithoutCancel(ctx), an, r.currentVersion(), res, err, r.opts.Traceparent); err != nil {
+		res.warn("failed to publish run annotation: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appVersionGuard caps up migrations at the schema version supported by the
+// deployed application. Source is either an http(s) URL or an SQL query run
+// against the target database; it reports the application version, which is
+// translated through CompatMap when one is given and used as the maximum
+// schema version directly otherwise.
+type appVersionGuard struct {
+	Source    string
+	CompatMap map[string]uint
+}
+
+func loadCompatMap(path string) (map[string]uint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var compat map[string]uint
+	if err := json.Unmarshal(data, &compat); err != nil {
+		return nil, fmt.Errorf("failed to parse compatibility map: %w", err)
+	}
+	return compat, nil
+}
+
+func (g *appVersionGuard) maxSchemaVersion(ctx context.Context, db *sql.DB) (uint, error) {
+	var reported string
+	var err error
+	if strings.HasPrefix(g.Source, "http://") || strings.HasPrefix(g.Source, "https://") {
+		reported, err = fetchAppVersion(ctx, g.Source)
+	} else {
+		err = db.QueryRowContext(ctx, g.Source).Scan(&reported)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get application version: %w", err)
+	}
+	reported = strings.TrimSpace(reported)
+
+	if g.CompatMap != nil {
+		limit, ok := g.CompatMap[reported]
+		if !ok {
+			return 0, fmt.Errorf("application version %q is not in the compatibility map", reported)
+		}
+		return limit, nil
+	}
+
+	limit, err := strconv.ParseUint(reported, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("application reported %q, expected a schema version number", reported)
+	}
+	return uint(limit), nil
+}
+
+// fetchAppVersion accepts either a plain text body or a JSON object with a
+// "version" field.
+func fetchAppVersion(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	var doc struct {
+		Version json.RawMessage `json:"version"`
+	}
+	if err := json.Unmarshal(body, &doc); err == nil && len(doc.Version) > 0 {
+		var s string
+		if json.Unmarshal(doc.Version, &s) == nil {
+			return s, nil
+		}
+		return string(doc.Version), nil
+	}
+	return string(body), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+const assertDirective = "-- assert:"
+
+func parseAssertions(body string) []string {
+	var assertions []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, assertDirective) {
+			continue
+		}
+		if query := strings.TrimSpace(strings.TrimPrefix(line, assertDirective)); query != "" {
+			assertions = append(assertions, query)
+		}
+	}
+	return assertions
+}
+
+func checkAssertions(ctx context.Context, db *sql.DB, src source.Driver, version uint) error {
+	body, _, err := readUp(src, version)
+	if err != nil {
+		return fmt.Errorf("failed to read migration %d: %w", version, err)
+	}
+
+	for _, query := range parseAssertions(body) {
+		var ok bool
+		if err := db.QueryRowContext(ctx, query).Scan(&ok); err != nil {
+			return fmt.Errorf("assertion %q after migration %d failed: %w", query, version, err)
+		}
+		if !ok {
+			return fmt.Errorf("assertion %q after migration %d returned false", query, version)
+		}
+	}
+
+	return nil
+}
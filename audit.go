@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+type auditReport struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	Host        string          `json:"host"`
+	Database    string          `json:"database"`
+	Schema      string          `json:"schema"`
+	Since       time.Time       `json:"since"`
+	Until       time.Time       `json:"until"`
+	Entries     []auditEntry    `json:"entries"`
+	Signature   *auditSignature `json:"signature,omitempty"`
+}
+
+type auditEntry struct {
+	Version     uint      `json:"version"`
+	Name        string    `json:"name"`
+	Direction   string    `json:"direction"`
+	AppliedAt   time.Time `json:"applied_at"`
+	Checksum    string    `json:"checksum"`
+	DurationMs  int64     `json:"duration_ms"`
+	Actor       string    `json:"actor"`
+	Traceparent string    `json:"traceparent,omitempty"`
+}
+
+type auditSignature struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// parseTime accepts RFC 3339 timestamps as well as the shorter
+// "2006-01-02T15:04" and "2006-01-02" forms, interpreted as UTC.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339, YYYY-MM-DDTHH:MM or YYYY-MM-DD", value)
+}
+
+func exportAudit(ctx context.Context, cfg *Config, schema, since, until, format, out string) error {
+	signingKey := getEnv("AUDIT_SIGNING_KEY", "")
+	if signingKey == "" {
+		return fmt.Errorf("AUDIT_SIGNING_KEY is required to sign the report")
+	}
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown report format %q: use json or csv", format)
+	}
+
+	var err error
+	var sinceTime time.Time
+	untilTime := time.Now().UTC()
+	if since != "" {
+		if sinceTime, err = parseTime(since); err != nil {
+			return err
+		}
+	}
+	if until != "" {
+		if untilTime, err = parseTime(until); err != nil {
+			return err
+		}
+	}
+
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := newHistory(db, schema).entries(ctx, sinceTime, untilTime)
+	if err != nil {
+		return err
+	}
+
+	report := newAuditReport(cfg, schema, sinceTime, untilTime, entries)
+	var content string
+	if format == "csv" {
+		content, err = report.CSV(signingKey)
+	} else {
+		content, err = report.JSON(signingKey)
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(out, content); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d history entries", len(entries))
+	return nil
+}
+
+func newAuditReport(cfg *Config, schema string, since, until time.Time, entries []historyEntry) *auditReport {
+	// With DB_SRV the server is picked on every connection, so the report
+	// names the SRV record, as the target list does.
+	host := cfg.Host
+	if cfg.SRV != "" {
+		host = cfg.SRV
+	}
+	report := &auditReport{
+		GeneratedAt: time.Now().UTC(),
+		Host:        host,
+		Database:    cfg.DBName,
+		Schema:      schema,
+		Since:       since.UTC(),
+		Until:       until.UTC(),
+		Entries:     []auditEntry{},
+	}
+	for _, e := range entries {
+		report.Entries = append(report.Entries, auditEntry{
+			Version:     e.Version,
+			Name:        e.Name,
+			Direction:   e.Direction,
+			AppliedAt:   e.AppliedAt.UTC(),
+			Checksum:    e.Checksum,
+			DurationMs:  e.Duration.Milliseconds(),
+			Actor:       e.Actor,
+			Traceparent: e.Traceparent,
+		})
+	}
+	return report
+}
+
+func sign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// JSON renders the report with an HMAC-SHA256 signature computed over the
+// report rendered without the signature field.
+func (r *auditReport) JSON(key string) (string, error) {
+	r.Signature = nil
+	unsigned, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	r.Signature = &auditSignature{Algorithm: "HMAC-SHA256", Value: sign(key, unsigned)}
+	signed, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(signed) + "\n", nil
+}
+
+// CSV renders the report as CSV followed by a "# signature:" line holding the
+// HMAC-SHA256 of everything above it.
+func (r *auditReport) CSV(key string) (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := [][]string{
+		{"# generated_at", r.GeneratedAt.Format(time.RFC3339)},
+		{"# target", fmt.Sprintf("%s/%s/%s", r.Host, r.Database, r.Schema)},
+		{"# range", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339)},
+		{"version", "name", "direction", "applied_at", "checksum", "duration_ms", "actor", "traceparent"},
+	}
+	if err := w.WriteAll(header); err != nil {
+		return "", err
+	}
+	for _, e := range r.Entries {
+		record := []string{
+			strconv.FormatUint(uint64(e.Version), 10),
+			e.Name,
+			e.Direction,
+			e.AppliedAt.Format(time.RFC3339Nano),
+			e.Checksum,
+			strconv.FormatInt(e.DurationMs, 10),
+			e.Actor,
+			e.Traceparent,
+		}
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(&buf, "# signature: hmac-sha256=%s\n", sign(key, buf.Bytes()))
+	return buf.String(), nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ddlOperation is a statement of a migration that affects a whole table.
+type ddlOperation struct {
+	Kind  string
+	Table string
+}
+
+const identPattern = `(?:"[^"]+"|[A-Za-z_][A-Za-z0-9_$]*)(?:\.(?:"[^"]+"|[A-Za-z_][A-Za-z0-9_$]*))?`
+
+var (
+	alterTablePattern = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(` + identPattern + `)`)
+	clusterPattern    = regexp.MustCompile(`(?is)^CLUSTER\s+(?:VERBOSE\s+)?(` + identPattern + `)`)
+	vacuumFullPattern = regexp.MustCompile(`(?is)^VACUUM\s+(?:\([^)]*FULL[^)]*\)|FULL)\s+(?:VERBOSE\s+)?(` + identPattern + `)`)
+)
+
+var blockingPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+	// unless lists statement fragments that make the operation non-blocking.
+	unless []string
+}{
+	{"ALTER TABLE", alterTablePattern, []string{"VALIDATE CONSTRAINT", "NOT VALID"}},
+	{"CREATE INDEX", regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?(?:` + identPattern + `\s+)?ON\s+(?:ONLY\s+)?(` + identPattern + `)`), nil},
+	{"REINDEX", regexp.MustCompile(`(?is)^REINDEX\s+(?:\([^)]*\)\s*)?TABLE\s+(` + identPattern + `)`), []string{"CONCURRENTLY"}},
+	{"CLUSTER", clusterPattern, nil},
+	{"VACUUM FULL", vacuumFullPattern, nil},
+	{"TRUNCATE", regexp.MustCompile(`(?is)^TRUNCATE\s+(?:TABLE\s+)?(?:ONLY\s+)?(` + identPattern + `)`), nil},
+	{"LOCK TABLE", regexp.MustCompile(`(?is)^LOCK\s+(?:TABLE\s+)?(?:ONLY\s+)?(` + identPattern + `)`), nil},
+}
+
+// blockingOperations lists the statements of a migration that hold a lock
+// blocking writes to a table until they finish. CREATE INDEX CONCURRENTLY
+// and REINDEX CONCURRENTLY are not reported.
+func blockingOperations(body string) []ddlOperation {
+	var ops []ddlOperation
+	for _, stmt := range splitStatements(body) {
+		normalized := strings.ToUpper(strings.Join(strings.Fields(stmt), " "))
+		for _, p := range blockingPatterns {
+			match := p.re.FindStringSubmatch(stmt)
+			if match == nil || containsAny(normalized, p.unless) {
+				continue
+			}
+			ops = append(ops, ddlOperation{Kind: p.kind, Table: match[1]})
+			break
+		}
+	}
+	return ops
+}
+
+var (
+	alterTypePattern  = regexp.MustCompile(`(?is)\bALTER\s+(?:COLUMN\s+)?` + identPattern + `\s+(?:SET\s+DATA\s+)?TYPE\b`)
+	addDefaultPattern = regexp.MustCompile(`(?is)\bADD\s+(?:COLUMN\s+)?[^,]*\bDEFAULT\b`)
+	setPersistPattern = regexp.MustCompile(`(?is)\bSET\s+(?:LOGGED|UNLOGGED|ACCESS\s+METHOD)\b`)
+)
+
+// rewritingOperations lists the statements of a migration that write a new
+// copy of a table and so need about as much free disk as the table takes
+// until the migration commits. Some type changes are binary compatible and
+// skip the rewrite; they are reported anyway. A column default only forces
+// a rewrite before PostgreSQL 11.
+func rewritingOperations(body string, serverVersion int) []ddlOperation {
+	var ops []ddlOperation
+	for _, stmt := range splitStatements(body) {
+		if match := alterTablePattern.FindStringSubmatch(stmt); match != nil {
+			switch {
+			case alterTypePattern.MatchString(stmt):
+				ops = append(ops, ddlOperation{Kind: "ALTER COLUMN TYPE", Table: match[1]})
+			case setPersistPattern.MatchString(stmt):
+				ops = append(ops, ddlOperation{Kind: "ALTER TABLE SET", Table: match[1]})
+			case serverVersion < 110000 && addDefaultPattern.MatchString(stmt):
+				ops = append(ops, ddlOperation{Kind: "ADD COLUMN DEFAULT", Table: match[1]})
+			}
+			continue
+		}
+		if match := clusterPattern.FindStringSubmatch(stmt); match != nil {
+			ops = append(ops, ddlOperation{Kind: "CLUSTER", Table: match[1]})
+		} else if match := vacuumFullPattern.FindStringSubmatch(stmt); match != nil {
+			ops = append(ops, ddlOperation{Kind: "VACUUM FULL", Table: match[1]})
+		}
+	}
+	return ops
+}
+
+func containsAny(s string, fragments []string) bool {
+	for _, f := range fragments {
+		if strings.Contains(s, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStatements splits SQL on semicolons outside of quotes, E'...' escape
+// strings, dollar-quoted bodies and comments, and drops the comments.
+func splitStatements(body string) []string {
+	var statements []string
+	var b strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		b.Reset()
+	}
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '-' && strings.HasPrefix(body[i:], "--"):
+			end := strings.IndexByte(body[i:], '\n')
+			if end < 0 {
+				end = len(body) - i
+			}
+			i += end - 1
+			b.WriteByte(' ')
+
+		case c == '/' && strings.HasPrefix(body[i:], "/*"):
+			end := strings.Index(body[i+2:], "*/")
+			if end < 0 {
+				end = len(body) - i - 2
+			}
+			i += end + 3
+			b.WriteByte(' ')
+
+		case c == '\'' && i > 0 && (body[i-1] == 'E' || body[i-1] == 'e') && (i == 1 || !isIdentChar(body[i-2])):
+			end := escapeStringEnd(body[i+1:])
+			if end < 0 {
+				b.WriteString(body[i:])
+				i = len(body)
+				continue
+			}
+			b.WriteString(body[i : i+end+2])
+			i += end + 1
+
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(body[i+1:], c)
+			if end < 0 {
+				b.WriteString(body[i:])
+				i = len(body)
+				continue
+			}
+			b.WriteString(body[i : i+end+2])
+			i += end + 1
+
+		case c == '$':
+			tag := dollarTag(body[i:])
+			if tag == "" {
+				b.WriteByte(c)
+				continue
+			}
+			end := strings.Index(body[i+len(tag):], tag)
+			if end < 0 {
+				b.WriteString(body[i:])
+				i = len(body)
+				continue
+			}
+			stop := i + 2*len(tag) + end
+			b.WriteString(body[i:stop])
+			i = stop - 1
+
+		case c == ';':
+			flush()
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// escapeStringEnd returns the index of the quote closing an E'...' string
+// whose body starts s, or -1. Backslashes escape the next character.
+func escapeStringEnd(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// dollarTag returns the $tag$ that s starts with, or "".
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
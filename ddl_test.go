@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"single", "SELECT 1", []string{"SELECT 1"}},
+		{"several", "SELECT 1;\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";;  ;", nil},
+		{"line comment", "SELECT 1; -- drop; this\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"block comment", "SELECT /* a; b */ 1", []string{"SELECT   1"}},
+		{"quoted string", "SELECT 'a;b'; SELECT 2", []string{"SELECT 'a;b'", "SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s;'; SELECT 2", []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{"quoted identifier", `SELECT "a;b" FROM t`, []string{`SELECT "a;b" FROM t`}},
+		{"escape string", `SELECT E'it\'s;'; ALTER TABLE t ADD c int`, []string{`SELECT E'it\'s;'`, "ALTER TABLE t ADD c int"}},
+		{"escape string lower case", `SELECT e'\\'; SELECT 2`, []string{`SELECT e'\\'`, "SELECT 2"}},
+		{"identifier ending in e", `SELECT name'x;'`, []string{`SELECT name'x;'`}},
+		{"dollar quoted", "DO $$ BEGIN PERFORM 1; END $$; SELECT 2", []string{"DO $$ BEGIN PERFORM 1; END $$", "SELECT 2"}},
+		{"tagged dollar quote", "SELECT $fn$ a; $$ b $fn$", []string{"SELECT $fn$ a; $$ b $fn$"}},
+		{"positional parameter", "SELECT $1; SELECT 2", []string{"SELECT $1", "SELECT 2"}},
+		{"unterminated string", "SELECT 'a; b", []string{"SELECT 'a; b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockingOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []ddlOperation
+	}{
+		{"alter table", "ALTER TABLE users ADD COLUMN age int", []ddlOperation{{"ALTER TABLE", "users"}}},
+		{"alter table only", "alter table if exists only public.users drop column age", []ddlOperation{{"ALTER TABLE", "public.users"}}},
+		{"not valid constraint", "ALTER TABLE users ADD CONSTRAINT c CHECK (age > 0) NOT VALID", nil},
+		{"validate constraint", "ALTER TABLE users VALIDATE CONSTRAINT c", nil},
+		{"create index", "CREATE UNIQUE INDEX users_email ON users (email)", []ddlOperation{{"CREATE INDEX", "users"}}},
+		{"create index concurrently", "CREATE INDEX CONCURRENTLY users_email ON users (email)", nil},
+		{"reindex", "REINDEX TABLE users", []ddlOperation{{"REINDEX", "users"}}},
+		{"reindex concurrently", "REINDEX TABLE CONCURRENTLY users", nil},
+		{"truncate", "TRUNCATE TABLE logs", []ddlOperation{{"TRUNCATE", "logs"}}},
+		{"lock", `LOCK TABLE "Orders" IN ACCESS EXCLUSIVE MODE`, []ddlOperation{{"LOCK TABLE", `"Orders"`}}},
+		{"vacuum full", "VACUUM (FULL, VERBOSE) events", []ddlOperation{{"VACUUM FULL", "events"}}},
+		{"after escape string", `INSERT INTO t VALUES (E'it\'s;'); ALTER TABLE t ADD c int`, []ddlOperation{{"ALTER TABLE", "t"}}},
+		{"in comment", "-- ALTER TABLE users ADD c int\nSELECT 1", nil},
+		{"select", "SELECT * FROM users", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blockingOperations(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("blockingOperations(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritingOperations(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		serverVersion int
+		want          []ddlOperation
+	}{
+		{"alter type", "ALTER TABLE users ALTER COLUMN age TYPE bigint", 160000, []ddlOperation{{"ALTER COLUMN TYPE", "users"}}},
+		{"set data type", "ALTER TABLE users ALTER age SET DATA TYPE bigint", 160000, []ddlOperation{{"ALTER COLUMN TYPE", "users"}}},
+		{"set logged", "ALTER TABLE users SET LOGGED", 160000, []ddlOperation{{"ALTER TABLE SET", "users"}}},
+		{"add default on 11", "ALTER TABLE users ADD COLUMN active bool DEFAULT true", 110000, nil},
+		{"add default on 10", "ALTER TABLE users ADD COLUMN active bool DEFAULT true", 100000, []ddlOperation{{"ADD COLUMN DEFAULT", "users"}}},
+		{"add column", "ALTER TABLE users ADD COLUMN age int", 100000, nil},
+		{"cluster", "CLUSTER users USING users_pkey", 160000, []ddlOperation{{"CLUSTER", "users"}}},
+		{"vacuum full", "VACUUM FULL events", 160000, []ddlOperation{{"VACUUM FULL", "events"}}},
+		{"plain vacuum", "VACUUM events", 160000, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewritingOperations(tt.body, tt.serverVersion); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rewritingOperations(%q, %d) = %v, want %v", tt.body, tt.serverVersion, got, tt.want)
+			}
+		})
+	}
+}
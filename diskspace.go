@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// freeDiskSpace returns the free bytes reported by check: the path of a
+// directory on the database disk, when the tool runs on the database host,
+// or a SQL query returning the free bytes, e.g. from a monitoring extension.
+func freeDiskSpace(ctx context.Context, db *sql.DB, check string) (int64, error) {
+	if strings.HasPrefix(check, "/") {
+		return freeDiskSpaceAt(check)
+	}
+	var free int64
+	if err := db.QueryRowContext(ctx, check).Scan(&free); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
+
+// checkDiskSpace refuses to start when a pending migration rewrites tables
+// whose combined size exceeds the free disk space. The old copy of a table
+// is only released when its migration commits, so each migration needs room
+// for everything it rewrites.
+func (r *runner) checkDiskSpace(ctx context.Context, pending []migrationInfo) error {
+	if r.opts.DiskFreeCheck == "" {
+		return nil
+	}
+
+	var serverVersion int
+	if err := r.db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&serverVersion); err != nil {
+		return fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	var free int64
+	checked := false
+	for _, mi := range pending {
+		body, _, err := readUp(r.src, mi.Version)
+		if err != nil {
+			return err
+		}
+
+		var needed int64
+		var rewritten []string
+		for _, op := range rewritingOperations(body, serverVersion) {
+			st, err := r.tableStats(ctx, op.Table)
+			if err != nil {
+				return fmt.Errorf("failed to read size of table %s: %w", op.Table, err)
+			}
+			if st == nil || st.Bytes == 0 {
+				continue
+			}
+			needed += st.Bytes
+			rewritten = append(rewritten, fmt.Sprintf("%s (%s, %s)", st.Name, st.Pretty, op.Kind))
+		}
+		if needed == 0 {
+			continue
+		}
+
+		if !checked {
+			if free, err = freeDiskSpace(ctx, r.db, r.opts.DiskFreeCheck); err != nil {
+				return fmt.Errorf("failed to check free disk space: %w", err)
+			}
+			checked = true
+		}
+		if needed > free {
+			return fmt.Errorf("migration %d rewrites %s, needing about %s of disk, but only %s is free",
+				mi.Version, strings.Join(rewritten, ", "), formatBytes(needed), formatBytes(free))
+		}
+	}
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
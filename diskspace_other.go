@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+func freeDiskSpaceAt(path string) (int64, error) {
+	return 0, errors.New("checking free space of a path is not supported on this platform, use a SQL query")
+}
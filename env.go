@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadEnvFile reads KEY=VALUE pairs from path into the process environment
+// without overriding variables that are already set. Unquoted and
+// double-quoted values support ${VAR}, ${VAR:-default} and $VAR expansion,
+// looking variables up in the environment first and then in the lines of the
+// file read so far. Single-quoted values are taken literally.
+func loadEnvFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	vars, err := parseEnv(string(content))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, kv := range vars {
+		if _, ok := os.LookupEnv(kv[0]); ok {
+			continue
+		}
+		if err := os.Setenv(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseEnv(content string) ([][2]string, error) {
+	var vars [][2]string
+	defined := make(map[string]string)
+	lookup := func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := defined[name]
+		return value, ok
+	}
+
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	line := 0
+	for len(content) > 0 {
+		var raw string
+		raw, content, _ = strings.Cut(content, "\n")
+		line++
+
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+		raw = strings.TrimPrefix(raw, "export ")
+
+		key, value, ok := strings.Cut(raw, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			for end < 0 && len(content) > 0 {
+				var next string
+				next, content, _ = strings.Cut(content, "\n")
+				line++
+				value += "\n" + next
+				end = strings.Index(value[1:], "'")
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", line, key)
+			}
+			value = value[1 : end+1]
+
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			for end < 0 && len(content) > 0 {
+				var next string
+				next, content, _ = strings.Cut(content, "\n")
+				line++
+				value += "\n" + next
+				end = closingQuote(value)
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", line, key)
+			}
+			expanded, err := expandEnv(value[1:end], lookup, true)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			value = expanded
+
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			expanded, err := expandEnv(value, lookup, false)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			value = expanded
+		}
+
+		defined[key] = value
+		vars = append(vars, [2]string{key, value})
+	}
+
+	return vars, nil
+}
+
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func expandEnv(s string, lookup func(string) (string, bool), escapes bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && (escapes || s[i+1] == '$'):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			expr := s[i+2 : end]
+			name, fallback, hasFallback := strings.Cut(expr, ":-")
+			if !isEnvName(name) {
+				return "", fmt.Errorf("invalid variable name %q", name)
+			}
+			value, ok := lookup(name)
+			if (!ok || value == "") && hasFallback {
+				expanded, err := expandEnv(fallback, lookup, escapes)
+				if err != nil {
+					return "", err
+				}
+				value = expanded
+			}
+			b.WriteString(value)
+			i = end
+
+		case c == '$' && i+1 < len(s) && isEnvNameChar(s[i+1]):
+			end := i + 1
+			for end < len(s) && isEnvNameChar(s[end]) {
+				end++
+			}
+			value, _ := lookup(s[i+1 : end])
+			b.WriteString(value)
+			i = end - 1
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
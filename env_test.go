@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("MIGRATE_TEST_SET", "from-env")
+	t.Setenv("MIGRATE_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		content string
+		want    [][2]string
+	}{
+		{"plain", "A=1\nB = two \n", [][2]string{{"A", "1"}, {"B", "two"}}},
+		{"comments and blank lines", "# comment\n\nA=1 # trailing\n", [][2]string{{"A", "1"}}},
+		{"export prefix", "export A=1", [][2]string{{"A", "1"}}},
+		{"crlf", "A=1\r\nB=2\r\n", [][2]string{{"A", "1"}, {"B", "2"}}},
+		{"empty value", "A=", [][2]string{{"A", ""}}},
+		{"single quoted is literal", `A='${MIGRATE_TEST_SET} # \n'`, [][2]string{{"A", `${MIGRATE_TEST_SET} # \n`}}},
+		{"single quoted multiline", "A='one\ntwo'", [][2]string{{"A", "one\ntwo"}}},
+		{"double quoted escapes", `A="a\tb\"c"`, [][2]string{{"A", "a\tb\"c"}}},
+		{"double quoted multiline", "A=\"one\ntwo\"", [][2]string{{"A", "one\ntwo"}}},
+		{"braced from environment", "A=${MIGRATE_TEST_SET}", [][2]string{{"A", "from-env"}}},
+		{"bare from environment", "A=x$MIGRATE_TEST_SET.y", [][2]string{{"A", "xfrom-env.y"}}},
+		{"from earlier line", "A=1\nB=${A}2", [][2]string{{"A", "1"}, {"B", "12"}}},
+		{"environment wins over file", "MIGRATE_TEST_SET=file\nB=$MIGRATE_TEST_SET", [][2]string{{"MIGRATE_TEST_SET", "file"}, {"B", "from-env"}}},
+		{"default when unset", "A=${MIGRATE_TEST_UNSET:-fallback}", [][2]string{{"A", "fallback"}}},
+		{"default when empty", "A=${MIGRATE_TEST_EMPTY:-fallback}", [][2]string{{"A", "fallback"}}},
+		{"nested default", "A=${MIGRATE_TEST_UNSET:-${MIGRATE_TEST_SET}}", [][2]string{{"A", "from-env"}}},
+		{"unset without default", "A=[${MIGRATE_TEST_UNSET}]", [][2]string{{"A", "[]"}}},
+		{"escaped dollar", `A=\$HOME`, [][2]string{{"A", "$HOME"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnv(tt.content)
+			if err != nil {
+				t.Fatalf("parseEnv(%q): %v", tt.content, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEnv(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing equals", "A"},
+		{"missing key", "=1"},
+		{"unterminated single quote", "A='one"},
+		{"unterminated double quote", `A="one`},
+		{"unterminated brace", "A=${B"},
+		{"invalid name", "A=${B-C}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseEnv(tt.content); err == nil {
+				t.Errorf("parseEnv(%q) = %q, want an error", tt.content, got)
+			}
+		})
+	}
+}
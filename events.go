@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// event is one line of the -progress-events output.
+type event struct {
+	Event       string     `json:"event"`
+	Time        time.Time  `json:"time"`
+	Command     string     `json:"command,omitempty"`
+	Target      string     `json:"target,omitempty"`
+	Version     *uint      `json:"version,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Direction   string     `json:"direction,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Result      *runResult `json:"result,omitempty"`
+	Traceparent string     `json:"traceparent,omitempty"`
+}
+
+// eventWriter writes events as newline-delimited JSON. A nil writer
+// discards them.
+type eventWriter struct {
+	mu          sync.Mutex
+	enc         *json.Encoder
+	traceparent string
+}
+
+func newEventWriter(w io.Writer, traceparent string) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w), traceparent: traceparent}
+}
+
+func (w *eventWriter) emit(e event) {
+	if w == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.Traceparent = w.traceparent
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(e); err != nil {
+		log.Printf("Warning: writing progress event: %v", err)
+	}
+}
+
+func (w *eventWriter) runStarted(command string) {
+	w.emit(event{Event: "run-started", Command: command})
+}
+
+func (w *eventWriter) runFinished(command string, start time.Time, res *runResult, err error) {
+	duration := time.Since(start).Milliseconds()
+	w.emit(event{
+		Event:      "run-finished",
+		Command:    command,
+		DurationMs: &duration,
+		Status:     eventStatus(err),
+		Error:      eventError(err),
+		Result:     res,
+	})
+}
+
+// progress reports migration events of a single target.
+type progress struct {
+	events *eventWriter
+	target string
+}
+
+func (p progress) migrationStarted(mi migrationInfo, direction string) {
+	version := mi.Version
+	p.events.emit(event{
+		Event:     "migration-started",
+		Target:    p.target,
+		Version:   &version,
+		Name:      mi.Identifier,
+		Direction: direction,
+	})
+}
+
+func (p progress) migrationFinished(mi migrationInfo, direction string, elapsed time.Duration, err error) {
+	version := mi.Version
+	duration := elapsed.Milliseconds()
+	p.events.emit(event{
+		Event:      "migration-finished",
+		Target:     p.target,
+		Version:    &version,
+		Name:       mi.Identifier,
+		Direction:  direction,
+		DurationMs: &duration,
+		Status:     eventStatus(err),
+		Error:      eventError(err),
+	})
+}
+
+func eventStatus(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+func eventError(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
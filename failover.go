@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/lib/pq"
+)
+
+const maxRetryBackoff = 30 * time.Second
+
+// retryPolicy controls reconnecting after the connection to the database is
+// lost, typically because the primary failed over to another host.
+type retryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// do runs fn until it succeeds, fails for a reason other than the connection,
+// or the attempts run out. Each attempt dials again, so DNS names and SRV
+// records are resolved anew.
+func (p retryPolicy) do(ctx context.Context, what string, fn func() error) error {
+	err := fn()
+	delay := p.Backoff
+	for attempt := 1; err != nil && isConnectionError(err) && attempt <= p.Attempts; attempt++ {
+		log.Printf("Failed to %s: %v; retrying in %s (attempt %d/%d)", what, err, delay, attempt, p.Attempts)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, maxRetryBackoff)
+		err = fn()
+	}
+	return err
+}
+
+// isConnectionError reports whether err means the session is gone rather than
+// the statement being wrong. A read-only transaction error is included: after
+// a failover the old primary may come back as a standby.
+func isConnectionError(err error) bool {
+	err = unwrapDatabaseError(err)
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "admin_shutdown", "crash_shutdown", "cannot_connect_now", "read_only_sql_transaction":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+	return false
+}
+
+// unwrapDatabaseError returns the driver error wrapped by golang-migrate,
+// whose error type does not implement Unwrap.
+func unwrapDatabaseError(err error) error {
+	var dbErr database.Error
+	if errors.As(err, &dbErr) {
+		return dbErr.OrigErr
+	}
+	var dbErrPtr *database.Error
+	if errors.As(err, &dbErrPtr) {
+		return dbErrPtr.OrigErr
+	}
+	return err
+}
+
+// reconnect replaces the runner's connection, letting the driver pick the
+// host that now satisfies target_session_attrs.
+func (r *runner) reconnect(ctx context.Context) error {
+	if r.redial == nil {
+		return errors.New("reconnecting is not supported")
+	}
+	sess, err := r.redial(ctx)
+	if err != nil {
+		return err
+	}
+	r.close()
+	r.session = sess
+	r.history = newHistory(sess.db, r.schema)
+	return nil
+}
+
+// retry runs op and, when it fails because the connection was lost, reconnects
+// with exponential backoff and runs it again. Before running it again the
+// version table is checked: done reports whether the lost attempt had in fact
+// completed, and a dirty version means it was interrupted halfway, which is
+// never retried. golang-migrate commits the dirty flag before it runs the
+// migration body, so a connection lost during the body always ends here;
+// only losses between migrations are retried.
+func (r *runner) retry(ctx context.Context, res *runResult, op func() error, done func(version int) bool) error {
+	err := op()
+	delay := r.opts.Retry.Backoff
+	for attempt := 1; err != nil && isConnectionError(err) && attempt <= r.opts.Retry.Attempts; attempt++ {
+		res.warn("connection lost: %v; reconnecting in %s (attempt %d/%d)", err, delay, attempt, r.opts.Retry.Attempts)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, maxRetryBackoff)
+
+		if err = r.reconnect(ctx); err != nil {
+			continue
+		}
+
+		current := -1
+		version, dirty, verr := r.m.Version()
+		if verr == nil {
+			current = int(version)
+		} else if !errors.Is(verr, migrate.ErrNilVersion) {
+			err = verr
+			continue
+		}
+		if dirty {
+			return fmt.Errorf("connection lost while migration %d was running and it is marked dirty; check the database and use force", version)
+		}
+		if done(current) {
+			return nil
+		}
+		err = op()
+	}
+	return err
+}
@@ -6,5 +6,3 @@ require (
 	github.com/golang-migrate/migrate/v4 v4.19.1
 	github.com/lib/pq v1.11.2
 )
-
-require github.com/joho/godotenv v1.5.1 // indirect
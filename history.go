@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// history is an append-only log of applied and rolled back migrations kept
+// next to the golang-migrate version table, which only holds the current
+// version.
+type history struct {
+	db    *sql.DB
+	table string
+}
+
+func newHistory(db *sql.DB, schema string) *history {
+	return &history{db: db, table: historyTableName(schema)}
+}
+
+func historyTableName(schema string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(migrationsTable+"_history")
+}
+
+// historyTableSQL creates the history table, or adds the columns introduced
+// since it was created.
+func historyTableSQL(table string) (createSQL, alterSQL string) {
+	createSQL = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id         bigserial PRIMARY KEY,
+		version    bigint NOT NULL,
+		name       text NOT NULL,
+		direction  text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now(),
+		down_sql   text
+	)`, table)
+	alterSQL = fmt.Sprintf(`ALTER TABLE %s
+		ADD COLUMN IF NOT EXISTS checksum text,
+		ADD COLUMN IF NOT EXISTS duration_ms bigint,
+		ADD COLUMN IF NOT EXISTS actor text,
+		ADD COLUMN IF NOT EXISTS traceparent text`, table)
+	return createSQL, alterSQL
+}
+
+func (h *history) ensure(ctx context.Context) error {
+	createSQL, alterSQL := historyTableSQL(h.table)
+	if _, err := h.db.ExecContext(ctx, createSQL); err != nil {
+		return fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	if _, err := h.db.ExecContext(ctx, alterSQL); err != nil {
+		return fmt.Errorf("failed to upgrade history table: %w", err)
+	}
+	return nil
+}
+
+type historyEntry struct {
+	Version     uint
+	Name        string
+	Direction   string
+	AppliedAt   time.Time
+	Checksum    string
+	Duration    time.Duration
+	Actor       string
+	Traceparent string
+	DownSQL     sql.NullString
+}
+
+func (h *history) record(ctx context.Context, e historyEntry) error {
+	insertSQL := fmt.Sprintf(`INSERT INTO %s (version, name, direction, checksum, duration_ms, actor, traceparent, down_sql)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)`, h.table)
+	_, err := h.db.ExecContext(ctx, insertSQL, e.Version, e.Name, e.Direction, e.Checksum, e.Duration.Milliseconds(), e.Actor, e.Traceparent, e.DownSQL)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d in history: %w", e.Version, err)
+	}
+	return nil
+}
+
+// currentActor identifies who runs the migrations: MIGRATE_ACTOR when set,
+// otherwise the OS user and host name.
+func currentActor() string {
+	if actor := getEnv("MIGRATE_ACTOR", ""); actor != "" {
+		return actor
+	}
+
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// storedDown returns the name and the down SQL saved when version was last
+// applied.
+func (h *history) storedDown(ctx context.Context, version uint) (string, sql.NullString, error) {
+	query := fmt.Sprintf(`SELECT name, down_sql FROM %s WHERE version = $1 AND direction = 'up' ORDER BY id DESC LIMIT 1`, h.table)
+	var name string
+	var downSQL sql.NullString
+	err := h.db.QueryRowContext(ctx, query, version).Scan(&name, &downSQL)
+	if err == sql.ErrNoRows {
+		return "", sql.NullString{}, nil
+	}
+	if err != nil {
+		return "", sql.NullString{}, fmt.Errorf("failed to read history: %w", err)
+	}
+	return name, downSQL, nil
+}
+
+// lastDirection returns the direction of the latest entry of version other
+// than a failure, or "" if there is none.
+func (h *history) lastDirection(ctx context.Context, version uint) (string, error) {
+	query := fmt.Sprintf(`SELECT direction FROM %s WHERE version = $1 AND direction <> 'failed' ORDER BY id DESC LIMIT 1`, h.table)
+	var direction string
+	err := h.db.QueryRowContext(ctx, query, version).Scan(&direction)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read history: %w", err)
+	}
+	return direction, nil
+}
+
+// previousApplied returns the highest version below version that is still
+// applied according to the history, or -1 if there is none.
+func (h *history) previousApplied(ctx context.Context, version uint) (int, error) {
+	query := fmt.Sprintf(`SELECT version FROM (
+		SELECT DISTINCT ON (version) version, direction FROM %s WHERE direction <> 'failed' ORDER BY version, id DESC
+	) last WHERE direction = 'up' AND version < $1 ORDER BY version DESC LIMIT 1`, h.table)
+	var prev int
+	err := h.db.QueryRowContext(ctx, query, version).Scan(&prev)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read history: %w", err)
+	}
+	return prev, nil
+}
+
+// entries returns the history recorded in [since, until), oldest first. A
+// missing history table yields no entries.
+func (h *history) entries(ctx context.Context, since, until time.Time) ([]historyEntry, error) {
+	query := fmt.Sprintf(`SELECT version, name, direction, applied_at,
+		COALESCE(checksum, ''), COALESCE(duration_ms, 0), COALESCE(actor, ''), COALESCE(traceparent, '')
+		FROM %s WHERE applied_at >= $1 AND applied_at < $2 ORDER BY id`, h.table)
+	rows, err := h.db.QueryContext(ctx, query, since, until)
+	if err != nil {
+		if isUndefinedTable(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		var e historyEntry
+		var durationMs int64
+		if err := rows.Scan(&e.Version, &e.Name, &e.Direction, &e.AppliedAt, &e.Checksum, &durationMs, &e.Actor, &e.Traceparent); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		e.Duration = time.Duration(durationMs) * time.Millisecond
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// applied returns the latest up entry of every version that has not been
+// rolled back since, ordered by version.
+func (h *history) applied(ctx context.Context) ([]historyEntry, error) {
+	query := fmt.Sprintf(`SELECT version, name, COALESCE(checksum, '') FROM (
+		SELECT DISTINCT ON (version) version, name, direction, checksum FROM %s WHERE direction <> 'failed' ORDER BY version, id DESC
+	) last WHERE direction = 'up' ORDER BY version`, h.table)
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		if isUndefinedTable(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		e := historyEntry{Direction: "up"}
+		if err := rows.Scan(&e.Version, &e.Name, &e.Checksum); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "undefined_table"
+}
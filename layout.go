@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// Directory layouts of migration files, selected with -layout.
+const (
+	// layoutMigrate is the golang-migrate naming: 1_name.up.sql, 1_name.down.sql.
+	layoutMigrate = "migrate"
+	// layoutFolder keeps one folder per version: 1_name/up.sql, 1_name/down.sql.
+	layoutFolder = "folder"
+	// layoutFlyway uses Flyway naming: V1__name.sql and U1__name.sql for undo.
+	layoutFlyway = "flyway"
+)
+
+var (
+	folderPattern = regexp.MustCompile(`^([0-9]+)(?:_(.*))?$`)
+	flywayPattern = regexp.MustCompile(`^([VUR])(.*?)__(.*)\.sql$`)
+)
+
+func init() {
+	source.Register(layoutFolder, &layoutDriver{layout: layoutFolder})
+	source.Register(layoutFlyway, &layoutDriver{layout: layoutFlyway})
+}
+
+// scanLayout lists the migration files of dir laid out as layout. Raw holds
+// the slash-separated path of each file relative to dir.
+func scanLayout(layout, dir string) ([]*source.Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*source.Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		switch layout {
+		case layoutMigrate:
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if parsed, err := source.Parse(name); err == nil {
+				parsed.Raw = name
+				files = append(files, parsed)
+			}
+
+		case layoutFolder:
+			match := folderPattern.FindStringSubmatch(name)
+			if !entry.IsDir() || match == nil {
+				continue
+			}
+			version, err := strconv.ParseUint(match[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version in %s: %w", name, err)
+			}
+			for _, direction := range []source.Direction{source.Up, source.Down} {
+				file := string(direction) + ".sql"
+				if _, err := os.Stat(filepath.Join(dir, name, file)); os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				files = append(files, &source.Migration{
+					Version:    uint(version),
+					Identifier: match[2],
+					Direction:  direction,
+					Raw:        path.Join(name, file),
+				})
+			}
+
+		case layoutFlyway:
+			match := flywayPattern.FindStringSubmatch(name)
+			if !entry.Type().IsRegular() || match == nil {
+				continue
+			}
+			if match[1] == "R" {
+				return nil, fmt.Errorf("repeatable migration %s is not supported", name)
+			}
+			version, err := strconv.ParseUint(match[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("version of %s must be a whole number", name)
+			}
+			direction := source.Up
+			if match[1] == "U" {
+				direction = source.Down
+			}
+			files = append(files, &source.Migration{
+				Version:    uint(version),
+				Identifier: match[3],
+				Direction:  direction,
+				Raw:        name,
+			})
+
+		default:
+			return nil, fmt.Errorf("unknown layout %q: use %s, %s or %s", layout, layoutMigrate, layoutFolder, layoutFlyway)
+		}
+	}
+	return files, nil
+}
+
+// layoutDriver is a source driver for the folder and flyway layouts, opened
+// as folder:///path/to/migrations or flyway:///path/to/migrations.
+type layoutDriver struct {
+	layout     string
+	dir        string
+	migrations *source.Migrations
+}
+
+func (d *layoutDriver) Open(url string) (source.Driver, error) {
+	_, dir, ok := strings.Cut(url, "://")
+	if !ok || dir == "" {
+		return nil, fmt.Errorf("invalid %s source URL %q", d.layout, url)
+	}
+
+	files, err := scanLayout(d.layout, dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := source.NewMigrations()
+	for _, f := range files {
+		if !migrations.Append(f) {
+			return nil, fmt.Errorf("duplicate %s migration for version %d: %s", f.Direction, f.Version, f.Raw)
+		}
+	}
+	return &layoutDriver{layout: d.layout, dir: dir, migrations: migrations}, nil
+}
+
+func (d *layoutDriver) Close() error {
+	return nil
+}
+
+func (d *layoutDriver) First() (uint, error) {
+	if version, ok := d.migrations.First(); ok {
+		return version, nil
+	}
+	return 0, &os.PathError{Op: "first", Path: d.dir, Err: os.ErrNotExist}
+}
+
+func (d *layoutDriver) Prev(version uint) (uint, error) {
+	if prev, ok := d.migrations.Prev(version); ok {
+		return prev, nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("prev for version %d", version), Path: d.dir, Err: os.ErrNotExist}
+}
+
+func (d *layoutDriver) Next(version uint) (uint, error) {
+	if next, ok := d.migrations.Next(version); ok {
+		return next, nil
+	}
+	return 0, &os.PathError{Op: fmt.Sprintf("next for version %d", version), Path: d.dir, Err: os.ErrNotExist}
+}
+
+func (d *layoutDriver) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if m, ok := d.migrations.Up(version); ok {
+		return d.open(m)
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read up for version %d", version), Path: d.dir, Err: os.ErrNotExist}
+}
+
+func (d *layoutDriver) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if m, ok := d.migrations.Down(version); ok {
+		return d.open(m)
+	}
+	return nil, "", &os.PathError{Op: fmt.Sprintf("read down for version %d", version), Path: d.dir, Err: os.ErrNotExist}
+}
+
+func (d *layoutDriver) open(m *source.Migration) (io.ReadCloser, string, error) {
+	f, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(m.Raw)))
+	if err != nil {
+		return nil, "", err
+	}
+	return f, m.Identifier, nil
+}
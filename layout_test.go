@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+func makeLayoutDir(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("SELECT 1;\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScanLayout(t *testing.T) {
+	tests := []struct {
+		layout string
+		files  []string
+		want   []source.Migration
+	}{
+		{
+			layout: layoutMigrate,
+			files:  []string{"1_init.up.sql", "1_init.down.sql", "2_users.up.sql", "README.md"},
+			want: []source.Migration{
+				{Version: 1, Identifier: "init", Direction: source.Down, Raw: "1_init.down.sql"},
+				{Version: 1, Identifier: "init", Direction: source.Up, Raw: "1_init.up.sql"},
+				{Version: 2, Identifier: "users", Direction: source.Up, Raw: "2_users.up.sql"},
+			},
+		},
+		{
+			layout: layoutFolder,
+			files:  []string{"1_init/up.sql", "1_init/down.sql", "2/up.sql", "notes/up.sql"},
+			want: []source.Migration{
+				{Version: 1, Identifier: "init", Direction: source.Down, Raw: "1_init/down.sql"},
+				{Version: 1, Identifier: "init", Direction: source.Up, Raw: "1_init/up.sql"},
+				{Version: 2, Identifier: "", Direction: source.Up, Raw: "2/up.sql"},
+			},
+		},
+		{
+			layout: layoutFlyway,
+			files:  []string{"V1__create_users.sql", "U1__create_users.sql", "V2__add_email_index.sql", "notes.sql"},
+			want: []source.Migration{
+				{Version: 1, Identifier: "create_users", Direction: source.Down, Raw: "U1__create_users.sql"},
+				{Version: 1, Identifier: "create_users", Direction: source.Up, Raw: "V1__create_users.sql"},
+				{Version: 2, Identifier: "add_email_index", Direction: source.Up, Raw: "V2__add_email_index.sql"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			files, err := scanLayout(tt.layout, makeLayoutDir(t, tt.files...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]source.Migration, len(files))
+			for i, f := range files {
+				got[i] = *f
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Raw < got[j].Raw })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanLayout() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanLayoutErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		file   string
+	}{
+		{"flyway repeatable", layoutFlyway, "R__refresh_views.sql"},
+		{"flyway dotted version", layoutFlyway, "V1.1__add_column.sql"},
+		{"flyway underscore version", layoutFlyway, "V1_1__add_column.sql"},
+		{"flyway empty version", layoutFlyway, "V__add_column.sql"},
+		{"unknown layout", "liquibase", "1_init.up.sql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := scanLayout(tt.layout, makeLayoutDir(t, tt.file)); err == nil {
+				t.Errorf("scanLayout(%s, %s) = %+v, want an error", tt.layout, tt.file, got)
+			}
+		})
+	}
+}
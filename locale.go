@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// localeRequirements are the encoding, collation and ICU support the
+// migrations were written for, from DB_REQUIRE_ENCODING,
+// DB_REQUIRE_COLLATION and DB_REQUIRE_ICU.
+type localeRequirements struct {
+	Encoding  string
+	Collation string
+	ICU       bool
+}
+
+var indexPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?INDEX\b|\bPRIMARY\s+KEY\b|\bUNIQUE\b`)
+
+// createsIndex reports whether a migration builds an index, either directly
+// or through a primary key or unique constraint.
+func createsIndex(body string) bool {
+	for _, stmt := range splitStatements(body) {
+		if indexPattern.MatchString(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLocale refuses to run against a database whose encoding, collation
+// or ICU support does not match the requirements, and warns when the
+// collation version recorded by the database differs from the one the
+// operating system provides now (typically after an OS upgrade) while
+// pending migrations build indexes, which would be sorted inconsistently.
+func (r *runner) checkLocale(ctx context.Context, pending []migrationInfo, res *runResult) error {
+	req := r.opts.Locale
+	var encoding, collation string
+	err := r.db.QueryRowContext(ctx, `SELECT pg_encoding_to_char(encoding), datcollate
+		FROM pg_database WHERE datname = current_database()`).Scan(&encoding, &collation)
+	if err != nil {
+		return fmt.Errorf("failed to read database locale: %w", err)
+	}
+	if req.Encoding != "" && normalizeLocaleName(encoding) != normalizeLocaleName(req.Encoding) {
+		return fmt.Errorf("database encoding is %s, migrations require %s", encoding, req.Encoding)
+	}
+	if req.Collation != "" && normalizeLocaleName(collation) != normalizeLocaleName(req.Collation) {
+		return fmt.Errorf("database collation is %s, migrations require %s", collation, req.Collation)
+	}
+	if req.ICU {
+		var icu bool
+		if err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM pg_collation WHERE collprovider = 'i')`).Scan(&icu); err != nil {
+			return fmt.Errorf("failed to check ICU support: %w", err)
+		}
+		if !icu {
+			return fmt.Errorf("migrations require ICU collations, but the server has none")
+		}
+	}
+
+	var indexed []string
+	for _, mi := range pending {
+		body, _, err := readUp(r.src, mi.Version)
+		if err != nil {
+			return err
+		}
+		if createsIndex(body) {
+			indexed = append(indexed, fmt.Sprint(mi.Version))
+		}
+	}
+	if len(indexed) == 0 {
+		return nil
+	}
+
+	var serverVersion int
+	if err := r.db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&serverVersion); err != nil {
+		return fmt.Errorf("failed to get server version: %w", err)
+	}
+	query := `SELECT collname, collversion, pg_collation_actual_version(oid)
+		FROM pg_collation
+		WHERE collversion IS NOT NULL AND collversion IS DISTINCT FROM pg_collation_actual_version(oid)`
+	if serverVersion >= 150000 {
+		// The version of the database default collation is tracked in
+		// pg_database since PostgreSQL 15.
+		query += ` UNION ALL
+		SELECT 'database default', datcollversion, pg_database_collation_actual_version(oid)
+		FROM pg_database
+		WHERE datname = current_database() AND datcollversion IS NOT NULL
+			AND datcollversion IS DISTINCT FROM pg_database_collation_actual_version(oid)`
+	}
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		res.warn("failed to check collation versions: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, recorded string
+		var actual *string
+		if err := rows.Scan(&name, &recorded, &actual); err != nil {
+			return err
+		}
+		now := "unknown"
+		if actual != nil {
+			now = *actual
+		}
+		res.warn("collation %s was created with version %s but the system now provides %s; indexes built by migrations %s may be inconsistent, refresh the collation and reindex first",
+			name, recorded, now, strings.Join(indexed, ", "))
+	}
+	return rows.Err()
+}
+
+// normalizeLocaleName makes "en_US.UTF-8" and "en_US.utf8" compare equal.
+func normalizeLocaleName(name string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+}
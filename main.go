@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
-	"github.com/joho/godotenv"
+	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// migrationsTable is the version table of the schema; every -module gets
+// its own, named schema_migrations_<module>, and its own history table.
+var migrationsTable = "schema_migrations"
+
+var moduleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
 type Config struct {
 	Host     string
 	Port     string
@@ -22,23 +35,130 @@ type Config struct {
 	Password string
 	DBName   string
 	SSLMode  string
+	Options  string
+	// SRV names a DNS SRV record listing the hosts and ports; it replaces
+	// Host and Port and is looked up on every connection attempt.
+	SRV string
+	// TargetSessionAttrs selects the server among multiple hosts.
+	TargetSessionAttrs string
+	DefaultTablespace  string
+	// SessionParams are name=value runtime parameters set for every session.
+	SessionParams []string
+	// ReadOnly makes every session read-only once it is established.
+	ReadOnly bool
 }
 
 func loadConfig() *Config {
-	cfg := &Config{
-		Host:     getEnv("DB_HOST", ""),
-		Port:     getEnv("DB_PORT", "5432"),
-		User:     getEnv("DB_USER", ""),
-		Password: getEnv("DB_PASSWORD", ""),
-		DBName:   getEnv("DB_NAME", ""),
-		SSLMode:  getEnv("DB_SSLMODE", "disable"),
+	return &Config{
+		Host:               getEnv("DB_HOST", ""),
+		Port:               getEnv("DB_PORT", "5432"),
+		User:               getEnv("DB_USER", ""),
+		Password:           getEnv("DB_PASSWORD", ""),
+		DBName:             getEnv("DB_NAME", ""),
+		SSLMode:            getEnv("DB_SSLMODE", "disable"),
+		Options:            getEnv("DB_OPTIONS", ""),
+		SRV:                getEnv("DB_SRV", ""),
+		TargetSessionAttrs: getEnv("DB_TARGET_SESSION_ATTRS", ""),
+		DefaultTablespace:  getEnv("DB_DEFAULT_TABLESPACE", ""),
+		SessionParams:      splitSessionParams(getEnv("DB_SESSION_PARAMS", "")),
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+var sessionParamPattern = regexp.MustCompile(`^\s*[A-Za-z_][A-Za-z0-9_.]*\s*=`)
+
+// splitSessionParams splits DB_SESSION_PARAMS on the commas that start a
+// new name=value pair, so values may contain commas themselves, as in
+// search_path=app,public.
+func splitSessionParams(value string) []string {
+	var params []string
+	for _, item := range strings.Split(value, ",") {
+		switch {
+		case strings.TrimSpace(item) == "":
+		case len(params) > 0 && !sessionParamPattern.MatchString(item):
+			params[len(params)-1] = strings.TrimSpace(params[len(params)-1] + "," + item)
+		default:
+			params = append(params, strings.TrimSpace(item))
+		}
 	}
+	return params
+}
 
-	if cfg.Host == "" || cfg.User == "" || cfg.Password == "" || cfg.DBName == "" {
-		log.Fatal("Missing required database configuration: DB_HOST, DB_USER, DB_PASSWORD, DB_NAME")
+func (c *Config) validate() error {
+	if c.Host == "" && c.SRV == "" || c.User == "" || c.Password == "" || c.DBName == "" {
+		return fmt.Errorf("missing required database configuration: DB_HOST (or DB_SRV), DB_USER, DB_PASSWORD, DB_NAME")
 	}
 
-	return cfg
+	for _, param := range c.SessionParams {
+		if name, _, ok := strings.Cut(param, "="); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid session parameter %q in DB_SESSION_PARAMS: expected name=value", param)
+		}
+	}
+	return nil
+}
+
+func (c *Config) dsn() string {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		dsnValue(c.Host), dsnValue(c.Port), dsnValue(c.User), dsnValue(c.Password), dsnValue(c.DBName), dsnValue(c.SSLMode))
+	if tsa := c.targetSessionAttrs(); tsa != "" {
+		dsn += " target_session_attrs=" + tsa
+	}
+	if options := c.options(); options != "" {
+		dsn += " options=" + quoteDSNValue(options)
+	}
+	return dsn
+}
+
+// targetSessionAttrs defaults to read-write when DB_HOST lists several hosts,
+// so that migrations always run on the primary.
+func (c *Config) targetSessionAttrs() string {
+	if c.TargetSessionAttrs == "" && strings.Contains(c.Host, ",") {
+		return "read-write"
+	}
+	return c.TargetSessionAttrs
+}
+
+// options combines DB_OPTIONS with DB_SESSION_PARAMS and
+// DB_DEFAULT_TABLESPACE rendered as -c flags.
+func (c *Config) options() string {
+	var parts []string
+	if c.Options != "" {
+		parts = append(parts, c.Options)
+	}
+	params := c.SessionParams
+	if c.DefaultTablespace != "" {
+		params = append(params[:len(params):len(params)], "default_tablespace="+c.DefaultTablespace)
+	}
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		value = strings.ReplaceAll(strings.TrimSpace(value), `\`, `\\`)
+		value = strings.ReplaceAll(value, " ", `\ `)
+		parts = append(parts, fmt.Sprintf("-c %s=%s", strings.TrimSpace(name), value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// dsnValue quotes value only when libpq would not read it as is.
+func dsnValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n'\\") {
+		return quoteDSNValue(value)
+	}
+	return value
+}
+
+func quoteDSNValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
 }
 
 func getEnv(key, defaultValue string) string {
@@ -49,20 +169,75 @@ func getEnv(key, defaultValue string) string {
 }
 
 func main() {
-	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+	if err := loadEnvFile(".env"); err != nil && !os.IsNotExist(err) {
 		log.Printf("Warning: loading .env: %v", err)
 	}
 
 	var (
-		command        = flag.String("command", "up", "Migration command: up, down, force, version")
+		command        = flag.String("command", "up", "Migration command: up, down, pick, force, version, status, pending, history, diff, stats, generate-script, generate-undo-script, manifest, snapshot, audit-export")
 		steps          = flag.Int("steps", 0, "Number of migration steps (for up/down commands, 0 = all)")
 		version        = flag.Int("version", 0, "Version to force (for force command)")
 		schema         = flag.String("schema", "", "Database schema name (required)")
-		migrationsPath = flag.String("path", "", "Path to migrations directory (required)")
+		migrationsPath = flag.String("path", "", "Path to migrations directory or source URL of a registered source driver (required)")
+		module         = flag.String("module", "", "Migration stream within the schema: migrations are read from <path>/<module> and tracked in schema_migrations_<module>")
+		layout         = flag.String("layout", getEnv("MIGRATIONS_LAYOUT", layoutMigrate), "Layout of the migrations directory: migrate (1_name.up.sql), folder (1_name/up.sql) or flyway (V1__name.sql, U1__name.sql)")
+		from           = flag.Int("from", 0, "Version the script starts from (for generate-script and generate-undo-script commands)")
+		to             = flag.Int("to", 0, "Version the script ends at (for generate-script and generate-undo-script commands)")
+		out            = flag.String("out", "", "Output file (for generate-script, generate-undo-script, manifest and audit-export commands, default stdout) or directory (for snapshot command, default snapshots)")
+		check          = flag.Bool("check", false, "Compare the snapshots directory with the rendered SQL instead of writing it (for snapshot command)")
+		targetsFile    = flag.String("targets", "", "File listing target databases/schemas to migrate one after another (for up/down commands)")
+		batchID        = flag.String("batch-id", "", "Batch run identifier; completed targets are recorded in STATE_DSN and skipped when the batch is resumed")
+		batchSize      = flag.Int("batch-size", 0, "Migrate targets in waves of this many targets (with -targets, 0 = all at once)")
+		batchPause     = flag.Duration("batch-pause", 0, "Pause between waves (with -batch-size)")
+		overridesFile  = flag.String("overrides", "", "File with per-target overrides: exclude, frozen, max_version=N (with -targets)")
+		parallel       = flag.Int("parallel", 1, "Number of targets migrated concurrently (with -targets)")
+		maxPerHost     = flag.Int("max-per-host", 0, "Maximum number of targets migrated concurrently on the same host (with -targets, 0 = no limit)")
+		storeDown      = flag.Bool("store-down", false, "Store the down SQL of applied migrations in the history table so they can be rolled back without the migration files")
+		manifestPath   = flag.String("manifest", "", "Verify the migrations directory against this manifest before running")
+		since          = flag.String("since", "", "Start of the reported time range, inclusive (for audit-export command)")
+		until          = flag.String("until", "", "End of the reported time range, exclusive (for audit-export command, default now)")
+		at             = flag.String("at", "", "Report the version as of this time, from the history table (for status command; RFC 3339, YYYY-MM-DDTHH:MM or YYYY-MM-DD, UTC)")
+		window         = flag.Duration("window", time.Hour, "Show history entries this long before and after -at (for status command)")
+		format         = flag.String("format", "json", "Report format: json or csv (for audit-export command)")
+		requireApp     = flag.String("require-app-version", "", "URL or SQL query reporting the deployed application version; up never migrates beyond the schema version it supports")
+		compatMap      = flag.String("compat-map", "", "JSON file mapping application versions to the maximum supported schema version (with -require-app-version)")
+		readOnly       = flag.Bool("read-only", false, "Run the session in read-only mode (always on for status, version, pending, history, diff, stats and audit-export)")
+		notifyChannel  = flag.String("notify-channel", "", "Send NOTIFY with the new schema version on this channel after up/down changed the schema (e.g. schema_changed)")
+		retryAttempts  = flag.Int("retry-attempts", 0, "Reconnect and retry this many times when the connection is lost during up/down, e.g. on a failover; a migration interrupted halfway stays dirty and is not retried (0 = fail immediately)")
+		retryBackoff   = flag.Duration("retry-backoff", time.Second, "Delay before the first reconnect attempt, doubled after each attempt up to 30s")
+		largeRows      = flag.Int64("large-table-rows", 1000000, "Warn before up runs blocking DDL on a table with at least this many rows (0 = off)")
+		largeSize      = flag.String("large-table-size", "1GB", "Warn before up runs blocking DDL on a table at least this large, in PostgreSQL size units (empty = off)")
+		diskFree       = flag.String("disk-free-check", "", "Directory on the database disk or SQL query returning free bytes; up refuses to start table rewrites that do not fit")
+		progressEvents = flag.Bool("progress-events", false, "Write progress events as newline-delimited JSON to stdout (for up/down commands)")
+		noPreflight    = flag.Bool("no-preflight", false, "Do not check the connections to all targets before a run with -targets")
+		project        = flag.String("project", getEnv("MIGRATIONS_PROJECT", ""), "Project the migrations belong to; recorded in the database on the first run and verified on later runs")
+		annotateURL    = flag.String("annotate-url", getEnv("ANNOTATE_URL", ""), "Grafana base URL or webhook URL to post start and end annotations of up/down runs to")
+		annotateFormat = flag.String("annotate-format", getEnv("ANNOTATE_FORMAT", annotateGrafana), "Annotation format: grafana or webhook")
+		traceparent    = flag.String("traceparent", getEnv("TRACEPARENT", ""), "W3C traceparent of the calling deployment, attached to progress events and history entries")
 	)
 	flag.Parse()
 
-	if *schema == "" {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Restore default signal handling so that a second signal kills the process.
+		signal.Stop(signals)
+		log.Println("Interrupted, stopping after the current migration")
+		cancel()
+	}()
+
+	if *batchID != "" && *targetsFile == "" {
+		log.Fatal("The -batch-id flag requires -targets")
+	}
+
+	if *overridesFile != "" && *targetsFile == "" {
+		log.Fatal("The -overrides flag requires -targets")
+	}
+
+	if *schema == "" && *targetsFile == "" {
 		log.Fatal("Schema name is required: use -schema flag")
 	}
 
@@ -70,120 +245,406 @@ func main() {
 		log.Fatal("Migrations path is required: use -path flag")
 	}
 
-	cfg := loadConfig()
+	path := *migrationsPath
+	if *module != "" {
+		if !moduleNamePattern.MatchString(*module) {
+			log.Fatalf("Invalid module name %q: use lowercase letters, digits and underscores", *module)
+		}
+		migrationsTable = "schema_migrations_" + *module
+		if strings.Contains(path, "://") {
+			path = strings.TrimSuffix(path, "/") + "/" + *module
+		} else {
+			path = filepath.Join(path, *module)
+		}
+	}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
+	sourceURL, migrationsDir, err := resolveSource(path, *layout)
+	if err != nil {
+		log.Fatalf("Invalid migrations path: %v", err)
+	}
+	if migrationsDir == "" && (*manifestPath != "" || *command == "manifest") {
+		log.Fatalf("Manifests require a local migrations directory, got %s", sourceURL)
+	}
 
-	db, err := sql.Open("postgres", dsn)
+	src, err := source.Open(sourceURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to open migrations source: %v", err)
 	}
-	defer db.Close()
+	defer src.Close()
 
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	if *manifestPath != "" && *command != "manifest" {
+		if err := verifyManifest(*manifestPath, migrationsDir, *layout); err != nil {
+			log.Fatalf("Migrations directory does not match manifest %s:\n%v", *manifestPath, err)
+		}
+		log.Printf("Migrations directory matches manifest %s", *manifestPath)
 	}
 
-	if err := createSchemaIfNotExists(db, *schema); err != nil {
-		log.Fatalf("Failed to create schema: %v", err)
+	switch *command {
+	case "manifest":
+		mf, err := buildManifest(migrationsDir, *layout)
+		if err != nil {
+			log.Fatalf("Failed to build manifest: %v", err)
+		}
+		content, err := mf.JSON()
+		if err != nil {
+			log.Fatalf("Failed to build manifest: %v", err)
+		}
+		if err := writeOutput(*out, content); err != nil {
+			log.Fatalf("Failed to write manifest: %v", err)
+		}
+		return
+
+	case "generate-script":
+		if *to == 0 {
+			log.Fatal("Target version is required for generate-script command: use -to flag")
+		}
+		script, err := generateScript(src, *schema, uint(*from), uint(*to))
+		if err != nil {
+			log.Fatalf("Failed to generate script: %v", err)
+		}
+		if err := writeOutput(*out, script); err != nil {
+			log.Fatalf("Failed to write script: %v", err)
+		}
+		return
+
+	case "generate-undo-script":
+		if *from == 0 {
+			log.Fatal("Start version is required for generate-undo-script command: use -from flag")
+		}
+		script, err := generateUndoScript(src, *schema, uint(*from), uint(*to))
+		if err != nil {
+			log.Fatalf("Failed to generate undo script: %v", err)
+		}
+		if err := writeOutput(*out, script); err != nil {
+			log.Fatalf("Failed to write script: %v", err)
+		}
+		return
+
+	case "snapshot":
+		dir := *out
+		if dir == "" {
+			dir = "snapshots"
+		}
+		if *check {
+			if err := checkSnapshots(src, *schema, dir); err != nil {
+				log.Fatalf("Snapshots in %s are out of date, run the snapshot command to update them:\n%v", dir, err)
+			}
+			log.Printf("Snapshots in %s are up to date", dir)
+			return
+		}
+		if err := writeSnapshots(src, *schema, dir); err != nil {
+			log.Fatalf("Failed to write snapshots: %v", err)
+		}
+		return
 	}
 
-	absPath, err := filepath.Abs(*migrationsPath)
-	if err != nil {
-		log.Fatalf("Failed to get absolute path: %v", err)
+	cfg := loadConfig()
+
+	if *traceparent != "" {
+		if *traceparent, err = parseTraceparent(*traceparent); err != nil {
+			log.Fatalf("Failed to parse traceparent: %v", err)
+		}
 	}
 
-	if _, err := os.Stat(absPath); os.IsNotExist(err) {
-		log.Fatalf("Migrations directory not found: %s", absPath)
+	var events *eventWriter
+	if *progressEvents && (*command == "up" || *command == "down" || *command == "pick") {
+		events = newEventWriter(os.Stdout, *traceparent)
+	}
+	run := runOptions{
+		StoreDown:     *storeDown,
+		Events:        events,
+		NotifyChannel: *notifyChannel,
+		Retry:         retryPolicy{Attempts: *retryAttempts, Backoff: *retryBackoff},
+		LargeTables:   tableThresholds{Rows: *largeRows, Size: *largeSize},
+		DiskFreeCheck: *diskFree,
+		Locale: localeRequirements{
+			Encoding:  getEnv("DB_REQUIRE_ENCODING", ""),
+			Collation: getEnv("DB_REQUIRE_COLLATION", ""),
+			ICU:       getEnv("DB_REQUIRE_ICU", "") == "true",
+		},
+		Traceparent: *traceparent,
+		Project:     *project,
+	}
+	if *annotateURL != "" {
+		if run.Annotations, err = newAnnotator(*annotateURL, *annotateFormat, getEnv("ANNOTATE_TOKEN", ""), splitList(getEnv("ANNOTATE_TAGS", ""))); err != nil {
+			log.Fatalf("Failed to configure annotations: %v", err)
+		}
+	}
+	if *requireApp != "" {
+		run.AppVersion = &appVersionGuard{Source: *requireApp}
+		if *compatMap != "" {
+			if run.AppVersion.CompatMap, err = loadCompatMap(*compatMap); err != nil {
+				log.Fatalf("Failed to load compatibility map: %v", err)
+			}
+		}
+	} else if *compatMap != "" {
+		log.Fatal("The -compat-map flag requires -require-app-version")
 	}
 
-	driver, err := postgres.WithInstance(db, &postgres.Config{
-		MigrationsTable: "schema_migrations",
-		SchemaName:      *schema,
-	})
-	if err != nil {
-		log.Fatalf("Failed to create postgres driver: %v", err)
+	var statusOpts statusOptions
+	if *at != "" {
+		if *command != "status" {
+			log.Fatal("The -at flag is supported only for the status command")
+		}
+		if statusOpts.At, err = parseTime(*at); err != nil {
+			log.Fatalf("Invalid -at: %v", err)
+		}
+		statusOpts.Window = *window
 	}
 
-	sourceURL := fmt.Sprintf("file://%s", absPath)
-	m, err := migrate.NewWithDatabaseInstance(sourceURL, "postgres", driver)
+	if *targetsFile != "" {
+		if *command != "up" && *command != "down" && *command != "status" {
+			log.Fatalf("The -targets flag is supported only for up, down and status commands")
+		}
+
+		targets, err := loadTargets(*targetsFile, cfg, *schema)
+		if err != nil {
+			log.Fatalf("Failed to load targets: %v", err)
+		}
+		if *overridesFile != "" {
+			overrides, err := loadOverrides(*overridesFile)
+			if err != nil {
+				log.Fatalf("Failed to load overrides: %v", err)
+			}
+			applyOverrides(targets, overrides)
+		}
+
+		if *command == "status" {
+			if err := printTargetsStatus(ctx, targets, src, statusOpts); err != nil {
+				log.Fatalf("Failed to run status: %v", err)
+			}
+			return
+		}
+
+		var state *stateStore
+		if *batchID != "" {
+			stateDSN := getEnv("STATE_DSN", "")
+			if stateDSN == "" {
+				log.Fatal("STATE_DSN is required for batch runs: set it to the coordination database DSN")
+			}
+			state, err = openStateStore(ctx, stateDSN)
+			if err != nil {
+				log.Fatalf("Failed to open state database: %v", err)
+			}
+			defer state.Close()
+		}
+
+		opts := batchOptions{
+			Parallel:   *parallel,
+			MaxPerHost: *maxPerHost,
+			BatchSize:  *batchSize,
+			BatchPause: *batchPause,
+			State:      state,
+			BatchID:    *batchID,
+			Preflight:  !*noPreflight,
+		}
+		start := time.Now()
+		events.runStarted(*command)
+		err = runTargets(ctx, targets, sourceURL, src, *command, *steps, run, opts)
+		events.runFinished(*command, start, nil, err)
+		if err != nil {
+			log.Fatalf("Batch run failed: %v", err)
+		}
+		return
+	}
+
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if *readOnly || readOnlyCommands[*command] {
+		cfg.ReadOnly = true
+	}
+
+	if *command == "audit-export" {
+		if err := exportAudit(ctx, cfg, *schema, *since, *until, *format, *out); err != nil {
+			log.Fatalf("Failed to export audit report: %v", err)
+		}
+		return
+	}
+
+	if readOnlyCommands[*command] {
+		var db *sql.DB
+		err := run.Retry.do(ctx, "connect", func() (err error) {
+			db, err = openDB(ctx, cfg)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Failed to prepare database: %v", err)
+		}
+		defer db.Close()
+
+		if err := runReadOnly(ctx, *command, db, src, *schema, statusOpts); err != nil {
+			log.Fatalf("Failed to run %s: %v", *command, err)
+		}
+		return
+	}
+
+	start := time.Now()
+	events.runStarted(*command)
+
+	var sess *session
+	err = run.Retry.do(ctx, "connect", func() (err error) {
+		sess, err = connect(ctx, cfg, *schema, sourceURL)
+		return err
+	})
 	if err != nil {
-		log.Fatalf("Failed to create migrate instance: %v", err)
+		events.runFinished(*command, start, nil, err)
+		log.Fatalf("Failed to prepare database: %v", err)
 	}
-	defer m.Close()
+	r := newRunner(sess, src, *schema, run, "")
+	r.redial = func(ctx context.Context) (*session, error) {
+		return connect(ctx, cfg, *schema, sourceURL)
+	}
+	defer r.close()
 
 	switch *command {
 	case "up":
-		if *steps > 0 {
-			err = m.Steps(*steps)
-		} else {
-			err = m.Up()
-		}
+		res, err := r.up(ctx, *steps)
+		events.runFinished(*command, start, res, ignoreNoChange(err))
 		if err != nil && err != migrate.ErrNoChange {
-			log.Fatalf("Migration failed: %v", err)
+			log.Fatalf("Migration failed after %s: %v", res, err)
 		}
 		if err == migrate.ErrNoChange {
 			log.Println("No migrations to apply")
 		} else {
-			log.Println("Migrations applied successfully")
+			log.Printf("Migrations applied successfully: %s", res)
 		}
 
 	case "down":
-		if *steps > 0 {
-			err = m.Steps(-*steps)
+		res, err := r.down(ctx, *steps)
+		events.runFinished(*command, start, res, ignoreNoChange(err))
+		if err != nil && err != migrate.ErrNoChange {
+			log.Fatalf("Migration failed after %s: %v", res, err)
+		}
+		if err == migrate.ErrNoChange {
+			log.Println("No migrations to rollback")
 		} else {
-			err = m.Down()
+			log.Printf("Migrations rolled back successfully: %s", res)
 		}
+
+	case "pick":
+		res, err := r.pick(ctx, os.Stdin, os.Stderr)
+		events.runFinished(*command, start, res, ignoreNoChange(err))
 		if err != nil && err != migrate.ErrNoChange {
-			log.Fatalf("Migration failed: %v", err)
+			log.Fatalf("Migration failed after %s: %v", res, err)
 		}
 		if err == migrate.ErrNoChange {
-			log.Println("No migrations to rollback")
+			log.Println("No migrations applied")
 		} else {
-			log.Println("Migrations rolled back successfully")
+			log.Printf("Selected migrations applied successfully: %s", res)
 		}
 
 	case "force":
 		if *version == 0 {
 			log.Fatal("Version is required for force command")
 		}
-		if err := m.Force(*version); err != nil {
+		if err := r.checkProject(ctx); err != nil {
 			log.Fatalf("Failed to force version: %v", err)
 		}
-		log.Printf("Version forced to: %d", *version)
-
-	case "version":
-		version, dirty, err := m.Version()
-		if err != nil {
-			if err == migrate.ErrNilVersion {
-				fmt.Println("Version: (no migrations applied)")
-				return
-			}
-			log.Fatalf("Failed to get version: %v", err)
-		}
-		if dirty {
-			fmt.Printf("Version: %d (dirty)\n", version)
-		} else {
-			fmt.Printf("Version: %d\n", version)
+		if err := r.m.Force(*version); err != nil {
+			log.Fatalf("Failed to force version: %v", err)
 		}
+		log.Printf("Version forced to: %d", *version)
 
 	default:
-		log.Fatalf("Unknown command: %s. Use: up, down, force, version", *command)
+		log.Fatalf("Unknown command: %s. Use: up, down, pick, force, version, status, pending, history, diff, stats, generate-script, generate-undo-script, manifest, snapshot, audit-export", *command)
+	}
+}
+
+func ignoreNoChange(err error) error {
+	if err == migrate.ErrNoChange {
+		return nil
+	}
+	return err
+}
+
+func openDB(ctx context.Context, cfg *Config) (*sql.DB, error) {
+	cfg, err := cfg.resolveSRV(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := pq.NewConnector(cfg.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	var db *sql.DB
+	if cfg.ReadOnly {
+		db = sql.OpenDB(readOnlyConnector{connector})
+	} else {
+		db = sql.OpenDB(connector)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return db, nil
+}
+
+// session is a connection prepared for migrating one schema. Migrations run
+// on conn, which the golang-migrate driver holds; db serves everything else.
+type session struct {
+	db     *sql.DB
+	conn   *sql.Conn
+	m      *migrate.Migrate
+	driver database.Driver
+}
+
+func (s *session) close() {
+	s.m.Close()
+	s.db.Close()
+}
+
+func connect(ctx context.Context, cfg *Config, schema, sourceURL string) (*session, error) {
+	db, err := openDB(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createSchemaIfNotExists(ctx, db, schema); err != nil {
+		db.Close()
+		return nil, err
 	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{
+		MigrationsTable: migrationsTable,
+		SchemaName:      schema,
+	})
+	if err != nil {
+		conn.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres driver: %w", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(sourceURL, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	return &session{db: db, conn: conn, m: m, driver: driver}, nil
 }
 
-func createSchemaIfNotExists(db *sql.DB, schemaName string) error {
+func createSchemaIfNotExists(ctx context.Context, db *sql.DB, schemaName string) error {
 	var exists bool
 	checkSQL := `SELECT EXISTS(SELECT 1 FROM information_schema.schemata WHERE schema_name = $1)`
-	err := db.QueryRow(checkSQL, schemaName).Scan(&exists)
+	err := db.QueryRowContext(ctx, checkSQL, schemaName).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("failed to check schema: %w", err)
 	}
 
 	if !exists {
 		createSQL := fmt.Sprintf("CREATE SCHEMA %s", schemaName)
-		_, err = db.Exec(createSQL)
+		_, err = db.ExecContext(ctx, createSQL)
 		if err != nil {
 			return fmt.Errorf("failed to create schema: %w", err)
 		}
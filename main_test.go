@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSessionParams(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"work_mem=64MB", []string{"work_mem=64MB"}},
+		{"maintenance_work_mem=2GB, synchronous_commit=off", []string{"maintenance_work_mem=2GB", "synchronous_commit=off"}},
+		{"search_path=app,public", []string{"search_path=app,public"}},
+		{"search_path=app, public,lock_timeout=5s", []string{"search_path=app, public", "lock_timeout=5s"}},
+		{"pg_trgm.similarity_threshold=0.5,", []string{"pg_trgm.similarity_threshold=0.5"}},
+		{"app,work_mem=64MB", []string{"app", "work_mem=64MB"}},
+	}
+	for _, tt := range tests {
+		if got := splitSessionParams(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSessionParams(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestConfigDSN(t *testing.T) {
+	base := Config{Host: "db1", Port: "5432", User: "migrator", Password: "secret", DBName: "app", SSLMode: "disable"}
+	const prefix = "host=db1 port=5432 user=migrator password=secret dbname=app sslmode=disable"
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"plain", func(c *Config) {}, prefix},
+		{
+			"password with spaces",
+			func(c *Config) { c.Password = "correct horse" },
+			"host=db1 port=5432 user=migrator password='correct horse' dbname=app sslmode=disable",
+		},
+		{
+			"password with quotes and backslashes",
+			func(c *Config) { c.Password = `it's\me` },
+			`host=db1 port=5432 user=migrator password='it\'s\\me' dbname=app sslmode=disable`,
+		},
+		{
+			"param with spaces",
+			func(c *Config) { c.SessionParams = []string{"application_name=nightly migrate"} },
+			prefix + ` options='-c application_name=nightly\\ migrate'`,
+		},
+		{
+			"param with backslashes",
+			func(c *Config) { c.SessionParams = []string{`application_name=a\b`} },
+			prefix + ` options='-c application_name=a\\\\b'`,
+		},
+		{
+			"param with quotes",
+			func(c *Config) { c.SessionParams = []string{"application_name=it's"} },
+			prefix + ` options='-c application_name=it\'s'`,
+		},
+		{
+			"param with commas",
+			func(c *Config) { c.SessionParams = []string{"search_path=app,public"} },
+			prefix + ` options='-c search_path=app,public'`,
+		},
+		{
+			"options, params and tablespace",
+			func(c *Config) {
+				c.Options = "-c role=migrator"
+				c.SessionParams = []string{"lock_timeout=5s", " work_mem = 64MB "}
+				c.DefaultTablespace = "fast_ssd"
+			},
+			prefix + ` options='-c role=migrator -c lock_timeout=5s -c work_mem=64MB -c default_tablespace=fast_ssd'`,
+		},
+		{
+			"multiple hosts",
+			func(c *Config) { c.Host, c.Port = "db1,db2", "5432,5433" },
+			"host=db1,db2 port=5432,5433 user=migrator password=secret dbname=app sslmode=disable target_session_attrs=read-write",
+		},
+		{
+			"multiple hosts with explicit target_session_attrs",
+			func(c *Config) { c.Host, c.TargetSessionAttrs = "db1,db2", "prefer-standby" },
+			"host=db1,db2 port=5432 user=migrator password=secret dbname=app sslmode=disable target_session_attrs=prefer-standby",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			tt.modify(&cfg)
+			if got := cfg.dsn(); got != tt.want {
+				t.Errorf("dsn() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
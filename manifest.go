@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type manifest struct {
+	HeadVersion uint            `json:"head_version"`
+	Files       []manifestEntry `json:"files"`
+}
+
+type manifestEntry struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+func buildManifest(dir, layout string) (*manifest, error) {
+	files, err := scanLayout(layout, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mf := &manifest{Files: []manifestEntry{}}
+	for _, f := range files {
+		sum, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(f.Raw)))
+		if err != nil {
+			return nil, err
+		}
+		mf.Files = append(mf.Files, manifestEntry{File: f.Raw, SHA256: sum})
+		if f.Version > mf.HeadVersion {
+			mf.HeadVersion = f.Version
+		}
+	}
+
+	sort.Slice(mf.Files, func(i, j int) bool { return mf.Files[i].File < mf.Files[j].File })
+	return mf, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return checksum(string(content)), nil
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func (mf *manifest) JSON() (string, error) {
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &mf, nil
+}
+
+// verifyManifest compares the migrations directory with the manifest and
+// reports every missing, modified or unexpected file.
+func verifyManifest(path, dir, layout string) error {
+	expected, err := loadManifest(path)
+	if err != nil {
+		return err
+	}
+	actual, err := buildManifest(dir, layout)
+	if err != nil {
+		return err
+	}
+
+	actualSums := make(map[string]string, len(actual.Files))
+	for _, f := range actual.Files {
+		actualSums[f.File] = f.SHA256
+	}
+
+	var problems []error
+	listed := make(map[string]bool, len(expected.Files))
+	for _, f := range expected.Files {
+		listed[f.File] = true
+		sum, ok := actualSums[f.File]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("missing file %s", f.File))
+		case sum != f.SHA256:
+			problems = append(problems, fmt.Errorf("checksum mismatch for %s", f.File))
+		}
+	}
+	for _, f := range actual.Files {
+		if !listed[f.File] {
+			problems = append(problems, fmt.Errorf("unexpected file %s", f.File))
+		}
+	}
+	if actual.HeadVersion != expected.HeadVersion {
+		problems = append(problems, fmt.Errorf("head version is %d, manifest expects %d", actual.HeadVersion, expected.HeadVersion))
+	}
+
+	return errors.Join(problems...)
+}
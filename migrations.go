@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+type migrationInfo struct {
+	Version    uint
+	Identifier string
+}
+
+func listMigrations(src source.Driver) ([]migrationInfo, error) {
+	var migrations []migrationInfo
+
+	version, err := src.First()
+	for err == nil {
+		_, identifier, readErr := readUp(src, version)
+		if readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read migration %d: %w", version, readErr)
+		}
+		migrations = append(migrations, migrationInfo{Version: version, Identifier: identifier})
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	return migrations, nil
+}
+
+func readUp(src source.Driver, version uint) (string, string, error) {
+	r, identifier, err := src.ReadUp(version)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", "", err
+	}
+	return string(body), identifier, nil
+}
+
+func readDown(src source.Driver, version uint) (string, string, error) {
+	r, identifier, err := src.ReadDown(version)
+	if err != nil {
+		return "", "", err
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", "", err
+	}
+	return string(body), identifier, nil
+}
+
+func pendingMigrations(m *migrate.Migrate, src source.Driver) ([]migrationInfo, error) {
+	all, err := listMigrations(src)
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+	if dirty {
+		return nil, migrate.ErrDirty{Version: int(current)}
+	}
+
+	return pendingAfter(all, int(current)), nil
+}
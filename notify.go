@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// notifySchemaChanged tells listening application instances that the schema
+// changed so they can drop cached statements and metadata. The migrations are
+// already committed at this point, so a failure only produces a warning.
+func (r *runner) notifySchemaChanged(ctx context.Context, res *runResult) {
+	if r.opts.NotifyChannel == "" {
+		return
+	}
+
+	payload := "0"
+	version, _, err := r.m.Version()
+	switch {
+	case err == nil:
+		payload = fmt.Sprint(version)
+	case !errors.Is(err, migrate.ErrNilVersion):
+		res.warn("failed to notify %s: %v", r.opts.NotifyChannel, err)
+		return
+	}
+
+	if _, err := r.db.ExecContext(context.WithoutCancel(ctx), "SELECT pg_notify($1, $2)", r.opts.NotifyChannel, payload); err != nil {
+		res.warn("failed to notify %s: %v", r.opts.NotifyChannel, err)
+	}
+}
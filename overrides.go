@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// targetOverride adjusts how a single target takes part in multi-target
+// runs without editing the targets file.
+type targetOverride struct {
+	MaxVersion *uint
+	Exclude    bool
+	Frozen     bool
+	Reason     string
+}
+
+// loadOverrides reads one override per line: a target selector (its schema
+// name or the full host:port/dbname/schema name printed in run logs)
+// followed by "exclude", "frozen" or "max_version=N". Text after " #" is
+// kept as the reason shown in the run summary.
+func loadOverrides(path string) (map[string]targetOverride, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	overrides := make(map[string]targetOverride)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, reason, _ := strings.Cut(scanner.Text(), " #")
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		selector := fields[0]
+		o := targetOverride{Reason: strings.TrimSpace(reason)}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "exclude":
+				o.Exclude = true
+			case "frozen":
+				o.Frozen = true
+			case "max_version":
+				v, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid max_version %q", path, line, value)
+				}
+				pinned := uint(v)
+				o.MaxVersion = &pinned
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown override %q", path, line, field)
+			}
+		}
+
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("%s:%d: no override given for %s", path, line, selector)
+		}
+		if _, ok := overrides[selector]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate override for %s", path, line, selector)
+		}
+		overrides[selector] = o
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// applyOverrides attaches overrides to the matching targets. An override
+// for the full target name takes precedence over one for its schema.
+func applyOverrides(targets []target, overrides map[string]targetOverride) {
+	used := make(map[string]bool)
+	for i := range targets {
+		t := &targets[i]
+		for _, selector := range []string{t.String(), t.Schema} {
+			if o, ok := overrides[selector]; ok {
+				t.Override = o
+				used[selector] = true
+				break
+			}
+		}
+	}
+
+	for selector := range overrides {
+		if !used[selector] {
+			log.Printf("Warning: override for %s does not match any target", selector)
+		}
+	}
+}
+
+func (o targetOverride) describe() string {
+	if o.Reason != "" {
+		return o.Reason
+	}
+	return "no reason given"
+}
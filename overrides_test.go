@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := writeTestFile(t, "overrides.txt", `# overrides
+tenant_b exclude          # waiting for the maintenance window
+tenant_c frozen # legal hold
+
+db1:5432/app/tenant_d max_version=12
+tenant_e frozen max_version=3
+`)
+	got, err := loadOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	twelve, three := uint(12), uint(3)
+	want := map[string]targetOverride{
+		"tenant_b":              {Exclude: true, Reason: "waiting for the maintenance window"},
+		"tenant_c":              {Frozen: true, Reason: "legal hold"},
+		"db1:5432/app/tenant_d": {MaxVersion: &twelve},
+		"tenant_e":              {Frozen: true, MaxVersion: &three},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadOverrides() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadOverridesErrors(t *testing.T) {
+	tests := map[string]string{
+		"no action":           "tenant_a\n",
+		"unknown action":      "tenant_a pause\n",
+		"invalid max_version": "tenant_a max_version=x\n",
+		"negative version":    "tenant_a max_version=-1\n",
+		"duplicate":           "tenant_a exclude\ntenant_a frozen\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got, err := loadOverrides(writeTestFile(t, "overrides.txt", content)); err == nil {
+				t.Errorf("loadOverrides(%q) = %+v, want an error", content, got)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	targets := []target{
+		{Config: Config{Host: "db1", Port: "5432", DBName: "app"}, Schema: "tenant_a"},
+		{Config: Config{Host: "db2", Port: "5432", DBName: "app"}, Schema: "tenant_a"},
+		{Config: Config{Host: "db1", Port: "5432", DBName: "app"}, Schema: "tenant_b"},
+	}
+	applyOverrides(targets, map[string]targetOverride{
+		"tenant_a":              {Exclude: true},
+		"db2:5432/app/tenant_a": {Frozen: true},
+	})
+
+	want := []targetOverride{{Exclude: true}, {Frozen: true}, {}}
+	for i, tgt := range targets {
+		if !reflect.DeepEqual(tgt.Override, want[i]) {
+			t.Errorf("override of %s = %+v, want %+v", tgt, tgt.Override, want[i])
+		}
+	}
+}
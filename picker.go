@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// pick lists the pending migrations, lets the operator choose which of them
+// to apply and applies the selection in version order. Pending migrations
+// left out below a selected one are skipped for good: the version table only
+// keeps the highest applied version, so they stop being pending. Skipping
+// needs an explicit confirmation and is recorded in the history.
+func (r *runner) pick(ctx context.Context, in io.Reader, out io.Writer) (*runResult, error) {
+	return r.execute(ctx, "up", func(res *runResult) error {
+		pending, err := r.pendingUp(ctx, res)
+		if err != nil {
+			return err
+		}
+
+		reader := bufio.NewReader(in)
+		fmt.Fprintln(out, "Pending migrations:")
+		for i, mi := range pending {
+			fmt.Fprintf(out, "%4d) %d %s\n", i+1, mi.Version, mi.Identifier)
+		}
+		answer, err := prompt(reader, out, "Select migrations to apply (e.g. 1,3-5 or all), empty to cancel: ")
+		if err != nil {
+			return err
+		}
+		indexes, err := parseSelection(answer, len(pending))
+		if err != nil {
+			return err
+		}
+		if len(indexes) == 0 {
+			return migrate.ErrNoChange
+		}
+
+		var selected, skipped []migrationInfo
+		last := indexes[len(indexes)-1]
+		chosen := make(map[int]bool, len(indexes))
+		for _, i := range indexes {
+			chosen[i] = true
+		}
+		for i, mi := range pending[:last+1] {
+			if chosen[i] {
+				selected = append(selected, mi)
+			} else {
+				skipped = append(skipped, mi)
+			}
+		}
+
+		if len(skipped) > 0 {
+			fmt.Fprintln(out, "Warning: the selection leaves gaps. These migrations will be skipped and no longer be pending:")
+			for _, mi := range skipped {
+				fmt.Fprintf(out, "      %d %s\n", mi.Version, mi.Identifier)
+			}
+			answer, err := prompt(reader, out, "Type yes to skip them: ")
+			if err != nil {
+				return err
+			}
+			if answer != "yes" {
+				return errors.New("skipping migrations was not confirmed")
+			}
+		}
+
+		return r.applyPending(ctx, selected, res, func(mi migrationInfo, body string) error {
+			migr, err := migrate.NewMigration(io.NopCloser(strings.NewReader(body)), mi.Identifier, mi.Version, int(mi.Version))
+			if err != nil {
+				return err
+			}
+			if err := r.m.Run(migr); err != nil {
+				return err
+			}
+
+			// The skipped migrations below this one are now behind the
+			// recorded version.
+			for len(skipped) > 0 && skipped[0].Version < mi.Version {
+				s := skipped[0]
+				skipped = skipped[1:]
+				entry := historyEntry{Version: s.Version, Name: s.Identifier, Direction: "skip", Actor: r.actor, Traceparent: r.opts.Traceparent}
+				if err := r.history.record(context.WithoutCancel(ctx), entry); err != nil {
+					return err
+				}
+				res.warn("skipped migration %d %s", s.Version, s.Identifier)
+			}
+			return nil
+		})
+	})
+}
+
+func prompt(reader *bufio.Reader, out io.Writer, question string) (string, error) {
+	fmt.Fprint(out, question)
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// parseSelection turns "1,3-5" (1-based, "all" for everything) into sorted,
+// distinct 0-based indexes below n.
+func parseSelection(input string, n int) ([]int, error) {
+	if input == "" {
+		return nil, nil
+	}
+	if input == "all" {
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+
+	seen := make(map[int]bool)
+	var indexes []int
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", field)
+		}
+		lastNum := first
+		if isRange {
+			if lastNum, err = strconv.Atoi(to); err != nil {
+				return nil, fmt.Errorf("invalid selection %q", field)
+			}
+		}
+		if first < 1 || lastNum > n || first > lastNum {
+			return nil, fmt.Errorf("selection %q is out of range 1-%d", field, n)
+		}
+		for i := first - 1; i < lastNum; i++ {
+			if !seen[i] {
+				seen[i] = true
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
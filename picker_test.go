@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		input string
+		n     int
+		want  []int
+	}{
+		{"", 3, nil},
+		{"all", 3, []int{0, 1, 2}},
+		{"2", 3, []int{1}},
+		{"1,3", 3, []int{0, 2}},
+		{"3, 1", 3, []int{0, 2}},
+		{"2-4", 5, []int{1, 2, 3}},
+		{"1-2,2-3", 3, []int{0, 1, 2}},
+		{"5,1-2", 5, []int{0, 1, 4}},
+	}
+	for _, tt := range tests {
+		got, err := parseSelection(tt.input, tt.n)
+		if err != nil {
+			t.Errorf("parseSelection(%q, %d): %v", tt.input, tt.n, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelection(%q, %d) = %v, want %v", tt.input, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseSelectionErrors(t *testing.T) {
+	for _, input := range []string{"0", "4", "x", "1-", "-2", "3-1", "1-x", "2-4"} {
+		if got, err := parseSelection(input, 3); err == nil {
+			t.Errorf("parseSelection(%q, 3) = %v, want an error", input, got)
+		}
+	}
+}
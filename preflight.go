@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// preflight connects to every target before a run starts so that a bad host,
+// password or a standby is found up front instead of when its turn comes.
+// All targets are checked and every failure is reported.
+func preflight(ctx context.Context, targets []target, parallel, maxPerHost int, retry retryPolicy) error {
+	log.Printf("Checking connections to %d targets", len(targets))
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	queue := newTargetQueue(append([]target(nil), targets...), maxPerHost)
+	for range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				t, ok := queue.next()
+				if !ok {
+					return
+				}
+				err := retry.do(ctx, "connect to "+t.String(), func() error {
+					return checkTarget(ctx, t)
+				})
+				queue.done(t)
+
+				if err != nil {
+					mu.Lock()
+					log.Printf("[%s] Preflight failed: %v", t, err)
+					failed = append(failed, t.String())
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.Printf("Preflight: %d of %d targets reachable", len(targets)-len(failed), len(targets))
+	if len(failed) > 0 {
+		return fmt.Errorf("%d target(s) failed preflight: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return ctx.Err()
+}
+
+func checkTarget(ctx context.Context, t target) error {
+	db, err := openDB(ctx, &t.Config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var standby bool
+	if err := db.QueryRowContext(ctx, `SELECT pg_is_in_recovery()`).Scan(&standby); err != nil {
+		return fmt.Errorf("failed to check recovery state: %w", err)
+	}
+	if standby {
+		return errors.New("server is a standby")
+	}
+	return nil
+}
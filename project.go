@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+func projectTableName(schema string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(migrationsTable+"_project")
+}
+
+// checkProject guards against applying the migrations of one project to the
+// database of another. The first run with a project configured records it
+// next to the version table; later runs with a different project are refused.
+func (r *runner) checkProject(ctx context.Context) error {
+	table := projectTableName(r.schema)
+	if r.opts.Project == "" {
+		var recorded string
+		err := r.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT project FROM %s`, table)).Scan(&recorded)
+		if err == nil {
+			log.Printf("Warning: schema %s belongs to project %q; set MIGRATIONS_PROJECT to verify it", r.schema, recorded)
+		} else if err != sql.ErrNoRows && !isUndefinedTable(err) {
+			return fmt.Errorf("failed to read project: %w", err)
+		}
+		return nil
+	}
+
+	createSQL := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id          integer PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+		project     text NOT NULL,
+		recorded_at timestamptz NOT NULL DEFAULT now(),
+		actor       text
+	)`, table)
+	if _, err := r.db.ExecContext(ctx, createSQL); err != nil {
+		return fmt.Errorf("failed to create project table: %w", err)
+	}
+
+	insertSQL := fmt.Sprintf(`INSERT INTO %s (project, actor) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`, table)
+	res, err := r.db.ExecContext(ctx, insertSQL, r.opts.Project, r.actor)
+	if err != nil {
+		return fmt.Errorf("failed to record project: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Recorded project %q for schema %s", r.opts.Project, r.schema)
+		return nil
+	}
+
+	var recorded string
+	if err := r.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT project FROM %s`, table)).Scan(&recorded); err != nil {
+		return fmt.Errorf("failed to read project: %w", err)
+	}
+	if recorded != r.opts.Project {
+		return fmt.Errorf("schema %s belongs to project %q, not %q: check -path and the target database", r.schema, recorded, r.opts.Project)
+	}
+	return nil
+}
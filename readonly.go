@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// readOnlyConnector makes every connection read-only after it is
+// established instead of through startup options: lib/pq checks
+// target_session_attrs=read-write against the session it got, and
+// default_transaction_read_only=on in the options would make it reject
+// every host.
+type readOnlyConnector struct {
+	driver.Connector
+}
+
+func (c readOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("driver does not support making the session read-only")
+	}
+	if _, err := execer.ExecContext(ctx, "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
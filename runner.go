@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+type runOptions struct {
+	StoreDown  bool
+	Events     *eventWriter
+	AppVersion *appVersionGuard
+	// MaxVersion pins the target: up never goes beyond it.
+	MaxVersion *uint
+	// NotifyChannel, when set, receives a NOTIFY with the new version after
+	// a run that changed the schema.
+	NotifyChannel string
+	Retry         retryPolicy
+	LargeTables   tableThresholds
+	// DiskFreeCheck is a path or SQL query reporting free disk space on
+	// the database server; when set, up refuses to start table rewrites
+	// that do not fit.
+	DiskFreeCheck string
+	Locale        localeRequirements
+	// Traceparent is recorded with every history entry of the run.
+	Traceparent string
+	// Project identifies the application the migrations belong to; see
+	// checkProject.
+	Project string
+	// Annotations, when set, marks the start and end of the run on
+	// dashboards.
+	Annotations *annotator
+}
+
+type runner struct {
+	*session
+	src      source.Driver
+	schema   string
+	history  *history
+	actor    string
+	opts     runOptions
+	progress progress
+	// redial opens a new connection when the current one is lost.
+	redial func(context.Context) (*session, error)
+}
+
+func newRunner(sess *session, src source.Driver, schema string, opts runOptions, target string) *runner {
+	return &runner{
+		session:  sess,
+		src:      src,
+		schema:   schema,
+		history:  newHistory(sess.db, schema),
+		actor:    currentActor(),
+		opts:     opts,
+		progress: progress{events: opts.Events, target: target},
+	}
+}
+
+// close closes the current session. It is defined on the runner rather than
+// promoted from the embedded session so that a deferred r.close() closes the
+// session left after a reconnect, not the one it replaced.
+func (r *runner) close() {
+	r.session.close()
+}
+
+// runResult describes what a run did. It is filled in as migrations are
+// applied, so it is also meaningful when the run fails.
+type runResult struct {
+	Direction  string             `json:"direction"`
+	Applied    []migrationOutcome `json:"applied"`
+	HeldBack   []uint             `json:"held_back,omitempty"`
+	Warnings   []string           `json:"warnings,omitempty"`
+	Duration   time.Duration      `json:"-"`
+	DurationMs int64              `json:"duration_ms"`
+}
+
+type migrationOutcome struct {
+	Version    uint   `json:"version"`
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+func (res *runResult) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", msg)
+	res.Warnings = append(res.Warnings, msg)
+}
+
+func (res *runResult) applied(mi migrationInfo, elapsed time.Duration) {
+	res.Applied = append(res.Applied, migrationOutcome{
+		Version:    mi.Version,
+		Name:       mi.Identifier,
+		DurationMs: elapsed.Milliseconds(),
+	})
+}
+
+func (res *runResult) String() string {
+	versions := make([]string, len(res.Applied))
+	for i, o := range res.Applied {
+		versions[i] = fmt.Sprint(o.Version)
+	}
+	s := fmt.Sprintf("%s: %d migration(s) in %s", res.Direction, len(res.Applied), res.Duration.Round(time.Millisecond))
+	if len(versions) > 0 {
+		s += " [" + strings.Join(versions, ", ") + "]"
+	}
+	if len(res.HeldBack) > 0 {
+		s += fmt.Sprintf(", %d held back", len(res.HeldBack))
+	}
+	if len(res.Warnings) > 0 {
+		s += fmt.Sprintf(", %d warning(s)", len(res.Warnings))
+	}
+	return s
+}
+
+func (r *runner) up(ctx context.Context, steps int) (*runResult, error) {
+	return r.execute(ctx, "up", func(res *runResult) error {
+		return r.applyUp(ctx, steps, res)
+	})
+}
+
+func (r *runner) down(ctx context.Context, steps int) (*runResult, error) {
+	return r.execute(ctx, "down", func(res *runResult) error {
+		return r.applyDown(ctx, steps, res)
+	})
+}
+
+func (r *runner) execute(ctx context.Context, direction string, apply func(*runResult) error) (*runResult, error) {
+	res := &runResult{Direction: direction}
+	start := time.Now()
+	err := r.checkProject(ctx)
+	if err == nil {
+		an := r.annotateStarted(ctx, direction, res)
+		err = apply(res)
+		r.annotateFinished(ctx, an, res, ignoreNoChange(err))
+	}
+	if err == nil && len(res.Applied) > 0 {
+		r.notifySchemaChanged(ctx, res)
+	}
+	res.Duration = time.Since(start)
+	res.DurationMs = res.Duration.Milliseconds()
+	return res, err
+}
+
+func (r *runner) applyUp(ctx context.Context, steps int, res *runResult) error {
+	pending, err := r.pendingUp(ctx, res)
+	if err != nil {
+		return err
+	}
+	if steps > 0 && steps < len(pending) {
+		pending = pending[:steps]
+	}
+	return r.applyPending(ctx, pending, res, func(mi migrationInfo, body string) error {
+		return ignoreNoChange(r.m.Migrate(mi.Version))
+	})
+}
+
+// pendingUp lists the pending migrations up may apply, holding back those
+// above the version limits of the target and the application.
+func (r *runner) pendingUp(ctx context.Context, res *runResult) ([]migrationInfo, error) {
+	pending, err := pendingMigrations(r.m, r.src)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.MaxVersion != nil {
+		pending = res.holdBack(pending, *r.opts.MaxVersion, "pinned for this target")
+	}
+	if r.opts.AppVersion != nil && len(pending) > 0 {
+		limit, err := r.opts.AppVersion.maxSchemaVersion(ctx, r.db)
+		if err != nil {
+			return nil, err
+		}
+		pending = res.holdBack(pending, limit, "supported by the running application")
+	}
+	if len(pending) == 0 {
+		return nil, migrate.ErrNoChange
+	}
+	return pending, nil
+}
+
+// applyPending applies migrations one at a time with apply so that the
+// assertions of each migration are checked before the next one starts.
+// Cancelling ctx stops the run before the next migration; golang-migrate
+// does not support interrupting the migration in progress.
+func (r *runner) applyPending(ctx context.Context, pending []migrationInfo, res *runResult, apply func(mi migrationInfo, body string) error) error {
+	if err := r.checkLocale(ctx, pending, res); err != nil {
+		return err
+	}
+	if err := r.warnLargeTables(ctx, pending, res); err != nil {
+		return err
+	}
+	if err := r.checkDiskSpace(ctx, pending); err != nil {
+		return err
+	}
+
+	if err := r.history.ensure(ctx); err != nil {
+		return err
+	}
+
+	for _, mi := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry := historyEntry{Version: mi.Version, Name: mi.Identifier, Direction: "up", Actor: r.actor, Traceparent: r.opts.Traceparent}
+		body, _, err := readUp(r.src, mi.Version)
+		if err != nil {
+			return err
+		}
+		entry.Checksum = checksum(body)
+		if r.opts.StoreDown {
+			downBody, _, err := readDown(r.src, mi.Version)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			entry.DownSQL = sql.NullString{String: downBody, Valid: err == nil}
+		}
+
+		r.progress.migrationStarted(mi, "up")
+		start := time.Now()
+		err = r.retry(ctx, res, func() error {
+			return r.withTablespace(ctx, body, func() error {
+				return apply(mi, body)
+			})
+		}, func(version int) bool {
+			return version >= int(mi.Version)
+		})
+		entry.Duration = time.Since(start)
+		if err != nil {
+			r.recordFailure(ctx, entry, res)
+		} else {
+			// The migration has been applied, record it even if ctx was
+			// cancelled meanwhile.
+			err = r.history.record(context.WithoutCancel(ctx), entry)
+		}
+		if err == nil {
+			if err = checkAssertions(ctx, r.db, r.src, mi.Version); err != nil {
+				// The migration is committed; keep later runs from
+				// building on it until someone looks at the data.
+				res.applied(mi, entry.Duration)
+				err = r.markDirty(mi.Version, err)
+			}
+		}
+		r.progress.migrationFinished(mi, "up", time.Since(start), err)
+		if err != nil {
+			return err
+		}
+		res.applied(mi, entry.Duration)
+	}
+
+	return nil
+}
+
+// markDirty flags version as dirty after its assertions failed, so that up
+// refuses to continue until the version is forced.
+func (r *runner) markDirty(version uint, cause error) error {
+	if err := r.driver.SetVersion(int(version), true); err != nil {
+		return fmt.Errorf("%w; failed to mark version %d dirty: %v", cause, version, err)
+	}
+	return fmt.Errorf("%w; version %d is marked dirty, check the data and use force", cause, version)
+}
+
+func (res *runResult) holdBack(pending []migrationInfo, limit uint, why string) []migrationInfo {
+	var allowed []migrationInfo
+	for _, mi := range pending {
+		if mi.Version <= limit {
+			allowed = append(allowed, mi)
+		} else {
+			res.HeldBack = append(res.HeldBack, mi.Version)
+		}
+	}
+	if held := len(pending) - len(allowed); held > 0 {
+		res.warn("holding back %d migration(s) above version %d %s", held, limit, why)
+	}
+	return allowed
+}
+
+// applyDown rolls migrations back one at a time, all of them when steps is
+// 0. When the down file of a version is not available, the down SQL stored
+// in the history at apply time is used instead.
+func (r *runner) applyDown(ctx context.Context, steps int, res *runResult) error {
+	if err := r.history.ensure(ctx); err != nil {
+		return err
+	}
+
+	count := 0
+	for steps == 0 || count < steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		current, _, err := r.m.Version()
+		if err == migrate.ErrNilVersion {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// pick moves the version past the migrations it skipped; they were
+		// never applied, so there is nothing to roll back.
+		direction, err := r.history.lastDirection(ctx, current)
+		if err != nil {
+			return err
+		}
+		if direction == "skip" {
+			prev, err := r.previousVersion(ctx, current)
+			if err != nil {
+				return err
+			}
+			res.warn("migration %d was skipped and never applied, moving to version %s without running its down SQL", current, formatVersion(prev, false))
+			if err := r.driver.SetVersion(prev, false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		mi := migrationInfo{Version: current}
+		body, identifier, readErr := readDown(r.src, current)
+		if readErr == nil {
+			mi.Identifier = identifier
+		} else if !errors.Is(readErr, os.ErrNotExist) {
+			return readErr
+		}
+
+		var stored sql.NullString
+		if readErr != nil {
+			var name string
+			if name, stored, err = r.history.storedDown(ctx, current); err != nil {
+				return err
+			}
+			mi.Identifier = name
+		}
+
+		r.progress.migrationStarted(mi, "down")
+		start := time.Now()
+		if stored.Valid {
+			res.warn("down migration %d not found in source, using SQL stored in history", current)
+			body = stored.String
+		}
+		err = r.retry(ctx, res, func() error {
+			return r.withTablespace(ctx, body, func() error {
+				if stored.Valid {
+					return r.runStoredDown(ctx, current, body)
+				}
+				return r.m.Steps(-1)
+			})
+		}, func(version int) bool {
+			return version < int(current)
+		})
+		entry := historyEntry{
+			Version:     current,
+			Name:        mi.Identifier,
+			Direction:   "down",
+			Duration:    time.Since(start),
+			Actor:       r.actor,
+			Traceparent: r.opts.Traceparent,
+		}
+		if body != "" {
+			entry.Checksum = checksum(body)
+		}
+		if err != nil {
+			r.recordFailure(ctx, entry, res)
+		} else {
+			err = r.history.record(context.WithoutCancel(ctx), entry)
+		}
+		elapsed := time.Since(start)
+		r.progress.migrationFinished(mi, "down", elapsed, err)
+		if err != nil {
+			return err
+		}
+		res.applied(mi, elapsed)
+		count++
+	}
+
+	if count == 0 {
+		return migrate.ErrNoChange
+	}
+	return nil
+}
+
+// recordFailure adds a "failed" entry for a migration that did not apply so
+// that the history also tells how often migrations fail. Failed entries do
+// not change what the history considers applied.
+func (r *runner) recordFailure(ctx context.Context, e historyEntry, res *runResult) {
+	e.Direction = "failed"
+	e.DownSQL = sql.NullString{}
+	if err := r.history.record(context.WithoutCancel(ctx), e); err != nil {
+		res.warn("could not record the failure of migration %d in history: %v", e.Version, err)
+	}
+}
+
+// previousVersion returns the version that precedes version in the source,
+// or, when version is no longer in the source, the previous one still
+// applied according to the history.
+func (r *runner) previousVersion(ctx context.Context, version uint) (int, error) {
+	if prev, err := r.src.Prev(version); err == nil {
+		return int(prev), nil
+	}
+	return r.history.previousApplied(ctx, version)
+}
+
+func (r *runner) runStoredDown(ctx context.Context, version uint, body string) error {
+	target, err := r.previousVersion(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	migr, err := migrate.NewMigration(io.NopCloser(strings.NewReader(body)), "down from history", version, target)
+	if err != nil {
+		return err
+	}
+	return r.m.Run(migr)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/lib/pq"
+)
+
+func versionTableName(schema string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(migrationsTable)
+}
+
+func setVersionSQL(schema string, version uint) string {
+	table := versionTableName(schema)
+	if version == 0 {
+		return fmt.Sprintf("TRUNCATE %s;\n", table)
+	}
+	return fmt.Sprintf("TRUNCATE %s;\nINSERT INTO %s (version, dirty) VALUES (%d, false);\n", table, table, version)
+}
+
+// markDirtySQL records version as dirty the way golang-migrate does before it
+// runs a migration; version 0 means no migration.
+func markDirtySQL(schema string, version uint) string {
+	table := versionTableName(schema)
+	v := int(version)
+	if version == 0 {
+		v = -1
+	}
+	return fmt.Sprintf("TRUNCATE %s;\nINSERT INTO %s (version, dirty) VALUES (%d, true);\n", table, table, v)
+}
+
+// historySQL records a migration in the history table like up and down do.
+// The actor is the database user running the script.
+func historySQL(schema string, version uint, name, direction, body string) string {
+	return fmt.Sprintf("INSERT INTO %s (version, name, direction, checksum, actor) VALUES (%d, %s, %s, %s, session_user);\n",
+		historyTableName(schema), version, pq.QuoteLiteral(name), pq.QuoteLiteral(direction), pq.QuoteLiteral(checksum(body)))
+}
+
+// nonTransactionalPattern matches statements that cannot run inside a
+// transaction block and statements that control the transaction themselves.
+var nonTransactionalPattern = regexp.MustCompile(`(?is)^(?:` +
+	`(?:CREATE\s+(?:UNIQUE\s+)?|DROP\s+)INDEX\s+CONCURRENTLY\b|REINDEX\b.*\bCONCURRENTLY\b|` +
+	`ALTER\s+TABLE\b.*\bDETACH\s+PARTITION\b.*\bCONCURRENTLY\b|VACUUM\b|` +
+	`(?:CREATE|DROP)\s+(?:DATABASE|TABLESPACE)\b|ALTER\s+SYSTEM\b|` +
+	`(?:BEGIN|START\s+TRANSACTION|COMMIT|END|ROLLBACK)\b)`)
+
+func runsOutsideTransaction(body string) bool {
+	for _, stmt := range splitStatements(body) {
+		if nonTransactionalPattern.MatchString(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMigrationSQL renders one migration. It runs in a transaction together
+// with its assertions, the version table update (doneSQL) and the history
+// entry, so a failed assertion rolls the whole migration back. A migration
+// that cannot run in a transaction block is not wrapped: as under up, the
+// version is marked dirty (dirtySQL) before it runs and recorded after its
+// assertions pass, so a failure leaves the version dirty.
+func writeMigrationSQL(b *strings.Builder, header, body string, assertions []string, dirtySQL, doneSQL string) {
+	fmt.Fprintf(b, "\n-- %s\n", header)
+	tablespace := parseTablespace(body)
+
+	if runsOutsideTransaction(body) {
+		b.WriteString("-- Runs outside a transaction block.\n")
+		fmt.Fprintf(b, "BEGIN;\n%sCOMMIT;\n\n", dirtySQL)
+		if tablespace != "" {
+			fmt.Fprintf(b, "SET default_tablespace = %s;\n\n", pq.QuoteIdentifier(tablespace))
+		}
+		b.WriteString(strings.TrimSpace(body))
+		b.WriteString("\n\n")
+		if tablespace != "" {
+			b.WriteString("RESET default_tablespace;\n\n")
+		}
+		writeAssertions(b, assertions)
+		fmt.Fprintf(b, "BEGIN;\n%sCOMMIT;\n", doneSQL)
+		return
+	}
+
+	b.WriteString("BEGIN;\n\n")
+	if tablespace != "" {
+		fmt.Fprintf(b, "SET LOCAL default_tablespace = %s;\n\n", pq.QuoteIdentifier(tablespace))
+	}
+	b.WriteString(strings.TrimSpace(body))
+	b.WriteString("\n\n")
+	writeAssertions(b, assertions)
+	b.WriteString(doneSQL)
+	b.WriteString("COMMIT;\n")
+}
+
+func writeAssertions(b *strings.Builder, assertions []string) {
+	for _, query := range assertions {
+		query = strings.TrimRight(query, "; ")
+		fmt.Fprintf(b, "DO $assert$\nBEGIN\n\tIF (%s) IS NOT TRUE THEN\n\t\tRAISE EXCEPTION 'assertion failed: %%', %s;\n\tEND IF;\nEND\n$assert$;\n\n",
+			query, pq.QuoteLiteral(query))
+	}
+}
+
+// writeTablesSQL creates the schema, the version table and the history table
+// if they do not exist yet.
+func writeTablesSQL(b *strings.Builder, schema string) {
+	createHistory, alterHistory := historyTableSQL(historyTableName(schema))
+	fmt.Fprintf(b, "CREATE SCHEMA IF NOT EXISTS %s;\n", pq.QuoteIdentifier(schema))
+	fmt.Fprintf(b, "CREATE TABLE IF NOT EXISTS %s (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);\n", versionTableName(schema))
+	fmt.Fprintf(b, "%s;\n%s;\n", createHistory, alterHistory)
+}
+
+// generateScript renders the up migrations in the (from, to] range as a single
+// SQL script for applying them by hand. It updates the version and history
+// tables as up does; see writeMigrationSQL for how it differs.
+func generateScript(src source.Driver, schema string, from, to uint) (string, error) {
+	if to <= from {
+		return "", fmt.Errorf("target version %d must be greater than %d", to, from)
+	}
+
+	all, err := listMigrations(src)
+	if err != nil {
+		return "", err
+	}
+
+	var selected []migrationInfo
+	found := false
+	for _, mi := range all {
+		if mi.Version > from && mi.Version <= to {
+			selected = append(selected, mi)
+		}
+		if mi.Version == to {
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("migration %d not found", to)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Migrations for schema %s: %d -> %d\n\n", schema, from, to)
+	writeTablesSQL(&b, schema)
+
+	for _, mi := range selected {
+		body, _, err := readUp(src, mi.Version)
+		if err != nil {
+			return "", fmt.Errorf("failed to read migration %d: %w", mi.Version, err)
+		}
+
+		header := fmt.Sprintf("%d_%s (up)", mi.Version, mi.Identifier)
+		writeMigrationSQL(&b, header, body, parseAssertions(body), markDirtySQL(schema, mi.Version),
+			setVersionSQL(schema, mi.Version)+historySQL(schema, mi.Version, mi.Identifier, "up", body))
+	}
+
+	return b.String(), nil
+}
+
+// generateUndoScript renders the down migrations in the (to, from] range,
+// newest first, rolling the version table back after each of them.
+func generateUndoScript(src source.Driver, schema string, from, to uint) (string, error) {
+	if from <= to {
+		return "", fmt.Errorf("start version %d must be greater than %d", from, to)
+	}
+
+	all, err := listMigrations(src)
+	if err != nil {
+		return "", err
+	}
+
+	index := make(map[uint]int, len(all))
+	for i, mi := range all {
+		index[mi.Version] = i
+	}
+	if _, ok := index[from]; !ok {
+		return "", fmt.Errorf("migration %d not found", from)
+	}
+	if _, ok := index[to]; !ok && to != 0 {
+		return "", fmt.Errorf("migration %d not found", to)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Rollback for schema %s: %d -> %d\n\n", schema, from, to)
+	writeTablesSQL(&b, schema)
+
+	for i := index[from]; i >= 0 && all[i].Version > to; i-- {
+		mi := all[i]
+		body, _, err := readDown(src, mi.Version)
+		if err != nil {
+			return "", fmt.Errorf("failed to read down migration %d: %w", mi.Version, err)
+		}
+
+		var prev uint
+		if i > 0 {
+			prev = all[i-1].Version
+		}
+		header := fmt.Sprintf("%d_%s (down)", mi.Version, mi.Identifier)
+		writeMigrationSQL(&b, header, body, nil, markDirtySQL(schema, prev),
+			setVersionSQL(schema, prev)+historySQL(schema, mi.Version, mi.Identifier, "down", body))
+	}
+
+	return b.String(), nil
+}
+
+func writeOutput(path, content string) error {
+	if path == "" {
+		_, err := fmt.Print(content)
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o644)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+func openTestSource(t *testing.T, files map[string]string) source.Driver {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := source.Open("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+	return src
+}
+
+var scriptMigrations = map[string]string{
+	"1_users.up.sql":    "CREATE TABLE users (id bigint);\n",
+	"1_users.down.sql":  "DROP TABLE users;\n",
+	"2_email.up.sql":    "-- assert: SELECT count(*) = 0 FROM users WHERE email IS NULL\nALTER TABLE users ADD email text;\n",
+	"2_email.down.sql":  "ALTER TABLE users DROP email;\n",
+	"4_index.up.sql":    "CREATE INDEX CONCURRENTLY users_email ON users (email);\n",
+	"4_index.down.sql":  "DROP INDEX CONCURRENTLY users_email;\n",
+	"5_backfill.up.sql": "UPDATE users SET email = '';\n",
+}
+
+// inOrder reports whether parts occur in s one after another.
+func inOrder(s string, parts ...string) bool {
+	for _, p := range parts {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return true
+}
+
+func TestGenerateScript(t *testing.T) {
+	got, err := generateScript(openTestSource(t, scriptMigrations), "app", 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(got, "-- 1_users (up)") || strings.Contains(got, "-- 5_backfill (up)") {
+		t.Errorf("generateScript(1, 4) includes migrations outside the range:\n%s", got)
+	}
+	if !inOrder(got,
+		`CREATE TABLE IF NOT EXISTS "app"."schema_migrations_history"`,
+		"-- 2_email (up)\nBEGIN;",
+		"ALTER TABLE users ADD email text;",
+		"IF (SELECT count(*) = 0 FROM users WHERE email IS NULL) IS NOT TRUE THEN",
+		`VALUES (2, false);`,
+		`VALUES (2, 'email', 'up', `,
+		"COMMIT;",
+		"-- 4_index (up)",
+		`VALUES (4, true);`+"\nCOMMIT;",
+		"CREATE INDEX CONCURRENTLY users_email ON users (email);",
+		"BEGIN;",
+		`VALUES (4, false);`,
+		`VALUES (4, 'index', 'up', `,
+		"COMMIT;",
+	) {
+		t.Errorf("generateScript(1, 4) = \n%s", got)
+	}
+}
+
+func TestGenerateUndoScript(t *testing.T) {
+	got, err := generateUndoScript(openTestSource(t, scriptMigrations), "app", 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sections []string
+	for _, part := range strings.Split(got, "\n-- ")[1:] {
+		if header, _, _ := strings.Cut(part, "\n"); strings.HasSuffix(header, " (down)") {
+			sections = append(sections, part)
+		} else if len(sections) > 0 {
+			sections[len(sections)-1] += "\n-- " + part
+		}
+	}
+	want := []struct {
+		header  string
+		version string
+	}{
+		{"4_index (down)", "VALUES (2, false);"},
+		{"2_email (down)", "VALUES (1, false);"},
+		{"1_users (down)", ""},
+	}
+	if len(sections) != len(want) {
+		t.Fatalf("generateUndoScript(4, 0) has %d migrations, want %d:\n%s", len(sections), len(want), got)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(sections[i], w.header) {
+			t.Errorf("migration %d = %q, want %q", i, strings.SplitN(sections[i], "\n", 2)[0], w.header)
+		}
+		if w.version == "" {
+			if strings.Contains(sections[i], "(version, dirty) VALUES (0,") || strings.Contains(sections[i], "false);") {
+				t.Errorf("%s sets a version, want an empty version table:\n%s", w.header, sections[i])
+			}
+		} else if !strings.Contains(sections[i], w.version) {
+			t.Errorf("%s does not contain %q:\n%s", w.header, w.version, sections[i])
+		}
+	}
+	if !strings.Contains(sections[0], "VALUES (2, true);") {
+		t.Errorf("%s does not mark version 2 dirty before running:\n%s", want[0].header, sections[0])
+	}
+	if strings.Contains(got, "IS NOT TRUE") {
+		t.Errorf("generateUndoScript() checks up assertions:\n%s", got)
+	}
+}
+
+func TestGenerateScriptErrors(t *testing.T) {
+	src := openTestSource(t, scriptMigrations)
+	tests := []struct {
+		name string
+		fn   func() (string, error)
+		want string
+	}{
+		{"up empty range", func() (string, error) { return generateScript(src, "app", 2, 2) }, "target version 2 must be greater than 2"},
+		{"up missing target", func() (string, error) { return generateScript(src, "app", 0, 3) }, "migration 3 not found"},
+		{"undo empty range", func() (string, error) { return generateUndoScript(src, "app", 2, 4) }, "start version 2 must be greater than 4"},
+		{"undo missing start", func() (string, error) { return generateUndoScript(src, "app", 6, 0) }, "migration 6 not found"},
+		{"undo missing target", func() (string, error) { return generateUndoScript(src, "app", 4, 3) }, "migration 3 not found"},
+		{"undo missing down", func() (string, error) { return generateUndoScript(src, "app", 5, 4) }, "failed to read down migration 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fn()
+			if err == nil {
+				t.Fatalf("got %q, want an error", got)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMigrationSQLAssertions(t *testing.T) {
+	var b strings.Builder
+	writeMigrationSQL(&b, "1_users (up)", "DELETE FROM users;\n", []string{"SELECT count(*) = 0 FROM users WHERE name = 'x';"}, "-- dirty\n", "-- done\n")
+
+	want := `
+-- 1_users (up)
+BEGIN;
+
+DELETE FROM users;
+
+DO $assert$
+BEGIN
+	IF (SELECT count(*) = 0 FROM users WHERE name = 'x') IS NOT TRUE THEN
+		RAISE EXCEPTION 'assertion failed: %', 'SELECT count(*) = 0 FROM users WHERE name = ''x''';
+	END IF;
+END
+$assert$;
+
+-- done
+COMMIT;
+`
+	if got := b.String(); got != want {
+		t.Errorf("writeMigrationSQL() = %q, want %q", got, want)
+	}
+}
+
+func TestRunsOutsideTransaction(t *testing.T) {
+	tests := map[string]bool{
+		"CREATE TABLE t (id bigint);":                              false,
+		"CREATE UNIQUE INDEX CONCURRENTLY i ON t (id);":            true,
+		"create index concurrently i on t (id);":                   true,
+		"DROP INDEX CONCURRENTLY IF EXISTS i;":                     true,
+		"REINDEX INDEX CONCURRENTLY i;":                            true,
+		"ALTER TABLE t DETACH PARTITION t_2020 CONCURRENTLY;":      true,
+		"ALTER TABLE t DETACH PARTITION t_2020;":                   false,
+		"SELECT 1;\nVACUUM ANALYZE t;":                             true,
+		"ALTER SYSTEM SET work_mem = '64MB';":                      true,
+		"BEGIN;\nUPDATE t SET a = 1;\nCOMMIT;":                     true,
+		"DO $$ BEGIN PERFORM 1; END $$;":                           false,
+		"-- CREATE INDEX CONCURRENTLY later\nSELECT 1;":            false,
+		"-- add the index\nCREATE INDEX CONCURRENTLY i ON t (id);": true,
+		"INSERT INTO t VALUES ('CREATE INDEX CONCURRENTLY i');":    false,
+	}
+	for body, want := range tests {
+		if got := runsOutsideTransaction(body); got != want {
+			t.Errorf("runsOutsideTransaction(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+const snapshotHeader = "-- Snapshot of migration"
+
+// renderSnapshots renders every migration the way generate-script and
+// generate-undo-script would execute it, keyed by snapshot file name.
+func renderSnapshots(src source.Driver, schema string) (map[string]string, error) {
+	all, err := listMigrations(src)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string]string, len(all))
+	for i, mi := range all {
+		name := fmt.Sprintf("%d_%s", mi.Version, mi.Identifier)
+		up, _, err := readUp(src, mi.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %d: %w", mi.Version, err)
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s for schema %s\n", snapshotHeader, name, schema)
+		writeMigrationSQL(&b, name+" (up)", up, parseAssertions(up), markDirtySQL(schema, mi.Version),
+			setVersionSQL(schema, mi.Version)+historySQL(schema, mi.Version, mi.Identifier, "up", up))
+
+		down, _, err := readDown(src, mi.Version)
+		switch {
+		case err == nil:
+			var prev uint
+			if i > 0 {
+				prev = all[i-1].Version
+			}
+			writeMigrationSQL(&b, name+" (down)", down, nil, markDirtySQL(schema, prev),
+				setVersionSQL(schema, prev)+historySQL(schema, mi.Version, mi.Identifier, "down", down))
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("failed to read down migration %d: %w", mi.Version, err)
+		}
+
+		snapshots[name+".sql"] = b.String()
+	}
+	return snapshots, nil
+}
+
+// writeSnapshots writes the rendered SQL of every migration into dir and
+// removes snapshots of migrations that no longer exist. It refuses to touch
+// a directory holding other SQL files, such as the migrations themselves.
+func writeSnapshots(src source.Driver, schema, dir string) error {
+	snapshots, err := renderSnapshots(src, schema)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		ok, err := isSnapshot(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is not a snapshot: refusing to write snapshots into %s", path, dir)
+		}
+	}
+	for _, path := range existing {
+		if _, ok := snapshots[filepath.Base(path)]; !ok {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, content := range snapshots {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSnapshot reports whether the file at path starts with the snapshot header.
+func isSnapshot(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, len(snapshotHeader))
+	if _, err := io.ReadFull(f, head); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(head) == snapshotHeader, nil
+}
+
+// checkSnapshots reports every snapshot in dir that is missing, stale or
+// differs from the SQL the migrations render to now.
+func checkSnapshots(src source.Driver, schema, dir string) error {
+	snapshots, err := renderSnapshots(src, schema)
+	if err != nil {
+		return err
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []error
+	for _, path := range existing {
+		if _, ok := snapshots[filepath.Base(path)]; !ok {
+			problems = append(problems, fmt.Errorf("stale snapshot %s", filepath.Base(path)))
+		}
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, fmt.Errorf("missing snapshot %s", name))
+		case err != nil:
+			return err
+		case string(content) != snapshots[name]:
+			problems = append(problems, fmt.Errorf("snapshot %s differs from rendered SQL", name))
+		}
+	}
+
+	return errors.Join(problems...)
+}
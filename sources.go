@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// resolveSource turns the -path flag into a source URL. A plain path is a
+// local directory. A URL is opened by the source driver registered for its
+// scheme, so a custom source (an artifact store, say) only needs a file in
+// this package that calls source.Register from init. dir is the local
+// directory for plain paths and file:// URLs and empty otherwise; a local
+// directory in another layout is opened by the driver of that layout.
+func resolveSource(path, layout string) (sourceURL, dir string, err error) {
+	scheme, rest, ok := strings.Cut(path, "://")
+	if ok && scheme != "file" {
+		if !slices.Contains(source.List(), scheme) {
+			return "", "", fmt.Errorf("no migrations source registered for scheme %q (registered: %s)", scheme, strings.Join(source.List(), ", "))
+		}
+		return path, "", nil
+	}
+	if ok {
+		path = rest
+	}
+
+	dir, err = filepath.Abs(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "", "", fmt.Errorf("migrations directory not found: %s", dir)
+	}
+	switch layout {
+	case layoutMigrate:
+		return "file://" + dir, dir, nil
+	case layoutFolder, layoutFlyway:
+		return layout + "://" + dir, dir, nil
+	}
+	return "", "", fmt.Errorf("unknown layout %q: use %s, %s or %s", layout, layoutMigrate, layoutFolder, layoutFlyway)
+}
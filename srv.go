@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// resolveSRV returns the configuration with Host and Port replaced by the
+// targets of the DB_SRV record, in the priority and weight order the
+// resolver returns them. Several targets become a multi-host DSN.
+func (c *Config) resolveSRV(ctx context.Context) (*Config, error) {
+	if c.SRV == "" {
+		return c, nil
+	}
+
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", c.SRV)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up SRV record %s: %w", c.SRV, err)
+	}
+
+	hosts := make([]string, len(records))
+	ports := make([]string, len(records))
+	for i, rec := range records {
+		hosts[i] = strings.TrimSuffix(rec.Target, ".")
+		ports[i] = strconv.Itoa(int(rec.Port))
+	}
+
+	resolved := *c
+	resolved.Host = strings.Join(hosts, ",")
+	resolved.Port = strings.Join(ports, ",")
+	return &resolved, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// stateStore records which targets of a batch run have completed in a
+// coordination database shared by all runners, so an interrupted batch can
+// be resumed without re-running finished targets.
+type stateStore struct {
+	db *sql.DB
+}
+
+func openStateStore(ctx context.Context, dsn string) (*stateStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping state database: %w", err)
+	}
+
+	createSQL := `CREATE TABLE IF NOT EXISTS migrate_batch_targets (
+		batch_id     text NOT NULL,
+		target       text NOT NULL,
+		completed_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (batch_id, target)
+	)`
+	if _, err := db.ExecContext(ctx, createSQL); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create state table: %w", err)
+	}
+
+	return &stateStore{db: db}, nil
+}
+
+func (s *stateStore) completed(ctx context.Context, batchID string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT target FROM migrate_batch_targets WHERE batch_id = $1`, batchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch state: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err != nil {
+			return nil, fmt.Errorf("failed to read batch state: %w", err)
+		}
+		done[target] = true
+	}
+	return done, rows.Err()
+}
+
+func (s *stateStore) markCompleted(ctx context.Context, batchID, target string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO migrate_batch_targets (batch_id, target) VALUES ($1, $2)
+		ON CONFLICT (batch_id, target) DO NOTHING`, batchID, target)
+	if err != nil {
+		return fmt.Errorf("failed to record completed target: %w", err)
+	}
+	return nil
+}
+
+func (s *stateStore) Close() error {
+	return s.db.Close()
+}
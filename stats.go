@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+const statsTopTables = 10
+
+type monthStats struct {
+	Month  string
+	Up     int
+	Down   int
+	Failed int
+}
+
+// printStats summarizes the whole history: how many migrations ran per
+// month, how long they took, how often they failed, and which tables the
+// migration files alter most often.
+func printStats(ctx context.Context, db *sql.DB, src source.Driver, schema string) error {
+	entries, err := newHistory(db, schema).entries(ctx, time.Time{}, time.Now().Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	tables, err := alteredTables(src)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema: %s\n", schema)
+	if len(entries) == 0 {
+		fmt.Println("No history recorded")
+	} else {
+		fmt.Printf("History recorded since: %s\n", entries[0].AppliedAt.UTC().Format(time.RFC3339))
+		if err := printHistoryStats(entries); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Most altered tables (from migration files):")
+	if len(tables) == 0 {
+		fmt.Println("None")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tMIGRATIONS")
+	for _, t := range tables[:min(len(tables), statsTopTables)] {
+		fmt.Fprintf(w, "%s\t%d\n", t.Table, t.Migrations)
+	}
+	return w.Flush()
+}
+
+func printHistoryStats(entries []historyEntry) error {
+	var months []monthStats
+	var durations []time.Duration
+	runs, failed := 0, 0
+	for _, e := range entries {
+		month := e.AppliedAt.UTC().Format("2006-01")
+		if len(months) == 0 || months[len(months)-1].Month != month {
+			months = append(months, monthStats{Month: month})
+		}
+		m := &months[len(months)-1]
+		switch e.Direction {
+		case "up":
+			m.Up++
+			durations = append(durations, e.Duration)
+		case "down":
+			m.Down++
+		case "failed":
+			m.Failed++
+			failed++
+		default:
+			continue
+		}
+		runs++
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MONTH\tUP\tDOWN\tFAILED")
+	for _, m := range months {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", m.Month, m.Up, m.Down, m.Failed)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if len(durations) > 0 {
+		slices.Sort(durations)
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		fmt.Printf("Duration of up migrations (%d): avg %s, p50 %s, p90 %s, p99 %s, max %s\n", len(durations),
+			(total / time.Duration(len(durations))).Round(time.Millisecond),
+			percentile(durations, 50), percentile(durations, 90), percentile(durations, 99),
+			durations[len(durations)-1])
+	}
+	if runs > 0 {
+		fmt.Printf("Failure rate: %.1f%% (%d of %d)\n", float64(failed)*100/float64(runs), failed, runs)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+type tableChurn struct {
+	Table      string
+	Migrations int
+}
+
+// alteredTables counts for every table the up migrations with an ALTER TABLE
+// statement on it, most altered first. Unquoted names are case-insensitive
+// and compared in lower case.
+func alteredTables(src source.Driver) ([]tableChurn, error) {
+	all, err := listMigrations(src)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, mi := range all {
+		body, _, err := readUp(src, mi.Version)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, stmt := range splitStatements(body) {
+			match := alterTablePattern.FindStringSubmatch(stmt)
+			if match == nil {
+				continue
+			}
+			table := match[1]
+			if !strings.Contains(table, `"`) {
+				table = strings.ToLower(table)
+			}
+			if !seen[table] {
+				seen[table] = true
+				counts[table]++
+			}
+		}
+	}
+
+	tables := make([]tableChurn, 0, len(counts))
+	for table, n := range counts {
+		tables = append(tables, tableChurn{Table: table, Migrations: n})
+	}
+	slices.SortFunc(tables, func(a, b tableChurn) int {
+		return cmp.Or(cmp.Compare(b.Migrations, a.Migrations), strings.Compare(a.Table, b.Table))
+	})
+	return tables, nil
+}
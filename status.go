@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// readOnlyCommands only read the database: they neither create the schema
+// and version table nor take the migration lock, and they run in a read-only
+// session so they can be used with a read-only role.
+var readOnlyCommands = map[string]bool{
+	"status":       true,
+	"version":      true,
+	"pending":      true,
+	"history":      true,
+	"diff":         true,
+	"audit-export": true,
+	"stats":        true,
+}
+
+// readVersion reads the version table directly instead of going through the
+// golang-migrate driver, which locks and creates the table on open.
+func readVersion(ctx context.Context, db *sql.DB, schema string) (int, bool, error) {
+	var version int
+	var dirty bool
+	query := fmt.Sprintf(`SELECT version, dirty FROM %s LIMIT 1`, versionTableName(schema))
+	err := db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err == sql.ErrNoRows || err != nil && isUndefinedTable(err) {
+		return database.NilVersion, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+func pendingAfter(all []migrationInfo, current int) []migrationInfo {
+	var pending []migrationInfo
+	for _, mi := range all {
+		if current == database.NilVersion || mi.Version > uint(current) {
+			pending = append(pending, mi)
+		}
+	}
+	return pending
+}
+
+func formatVersion(version int, dirty bool) string {
+	if version == database.NilVersion {
+		return "(no migrations applied)"
+	}
+	if dirty {
+		return fmt.Sprintf("%d (dirty)", version)
+	}
+	return fmt.Sprintf("%d", version)
+}
+
+// statusOptions makes the status command report the version as of At and
+// the history within Window around it instead of the current state.
+type statusOptions struct {
+	At     time.Time
+	Window time.Duration
+}
+
+func runReadOnly(ctx context.Context, command string, db *sql.DB, src source.Driver, schema string, opts statusOptions) error {
+	if command == "status" && !opts.At.IsZero() {
+		return printStatusAt(ctx, db, schema, opts)
+	}
+
+	version, dirty, err := readVersion(ctx, db, schema)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "version":
+		fmt.Printf("Version: %s\n", formatVersion(version, dirty))
+		return nil
+
+	case "history":
+		return printHistory(ctx, db, schema)
+
+	case "stats":
+		return printStats(ctx, db, src, schema)
+	}
+
+	all, err := listMigrations(src)
+	if err != nil {
+		return err
+	}
+	pending := pendingAfter(all, version)
+
+	switch command {
+	case "status":
+		fmt.Printf("Schema: %s\n", schema)
+		fmt.Printf("Version: %s\n", formatVersion(version, dirty))
+		if len(all) > 0 {
+			fmt.Printf("Latest available: %d\n", all[len(all)-1].Version)
+		}
+		fmt.Printf("Pending: %d\n", len(pending))
+
+	case "pending":
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		for _, mi := range pending {
+			fmt.Printf("%d %s\n", mi.Version, mi.Identifier)
+		}
+
+	case "diff":
+		return printDiff(ctx, db, src, schema, all, pending)
+	}
+
+	return nil
+}
+
+func printHistory(ctx context.Context, db *sql.DB, schema string) error {
+	entries, err := newHistory(db, schema).entries(ctx, time.Time{}, time.Now().Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No history recorded")
+		return nil
+	}
+	return printEntries(entries)
+}
+
+// printStatusAt reconstructs the version at opts.At by replaying the history
+// recorded before it. Migrations applied before the history table existed
+// are not known, so the first recorded entry is reported as well.
+func printStatusAt(ctx context.Context, db *sql.DB, schema string, opts statusOptions) error {
+	h := newHistory(db, schema)
+	before, err := h.entries(ctx, time.Time{}, opts.At)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[uint]bool)
+	for _, e := range before {
+		if e.Direction != "failed" {
+			applied[e.Version] = e.Direction == "up"
+		}
+	}
+	version := database.NilVersion
+	for v, ok := range applied {
+		if ok && int(v) > version {
+			version = int(v)
+		}
+	}
+
+	fmt.Printf("Schema: %s\n", schema)
+	fmt.Printf("Version at %s: %s\n", opts.At.UTC().Format(time.RFC3339), formatVersion(version, false))
+	if len(before) == 0 {
+		fmt.Println("No history recorded before this time")
+	} else {
+		fmt.Printf("History recorded since: %s\n", before[0].AppliedAt.UTC().Format(time.RFC3339))
+	}
+
+	from, until := opts.At.Add(-opts.Window), opts.At.Add(opts.Window)
+	window, err := h.entries(ctx, from, until)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Changes between %s and %s:\n", from.UTC().Format(time.RFC3339), until.UTC().Format(time.RFC3339))
+	if len(window) == 0 {
+		fmt.Println("None")
+		return nil
+	}
+	return printEntries(window)
+}
+
+func printEntries(entries []historyEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLIED AT\tDIRECTION\tVERSION\tNAME\tDURATION\tACTOR")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.AppliedAt.UTC().Format(time.RFC3339), e.Direction, e.Version, e.Name, e.Duration, e.Actor)
+	}
+	return w.Flush()
+}
+
+// printDiff compares the migrations directory with what the history says is
+// applied: applied migrations missing from the directory or changed since
+// they were applied, and pending migrations.
+func printDiff(ctx context.Context, db *sql.DB, src source.Driver, schema string, all, pending []migrationInfo) error {
+	applied, err := newHistory(db, schema).applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	available := make(map[uint]bool, len(all))
+	for _, mi := range all {
+		available[mi.Version] = true
+	}
+
+	differences := 0
+	for _, e := range applied {
+		if !available[e.Version] {
+			fmt.Printf("missing  %d %s (applied, not in migrations directory)\n", e.Version, e.Name)
+			differences++
+			continue
+		}
+		if e.Checksum == "" {
+			continue
+		}
+		body, _, err := readUp(src, e.Version)
+		if err != nil {
+			return fmt.Errorf("failed to read migration %d: %w", e.Version, err)
+		}
+		if checksum(body) != e.Checksum {
+			fmt.Printf("changed  %d %s (file differs from the applied version)\n", e.Version, e.Name)
+			differences++
+		}
+	}
+	for _, mi := range pending {
+		fmt.Printf("pending  %d %s\n", mi.Version, mi.Identifier)
+		differences++
+	}
+
+	if differences == 0 {
+		fmt.Println("No differences")
+	}
+	return nil
+}
+
+// printTargetsStatus runs the status command against every target in turn.
+func printTargetsStatus(ctx context.Context, targets []target, src source.Driver, opts statusOptions) error {
+	var failed []string
+	for i, t := range targets {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Target: %s\n", t)
+
+		cfg := t.Config
+		cfg.ReadOnly = true
+		db, err := openDB(ctx, &cfg)
+		if err == nil {
+			err = runReadOnly(ctx, "status", db, src, t.Schema, opts)
+			db.Close()
+		}
+		if err != nil {
+			log.Printf("[%s] Failed to get status: %v", t, err)
+			failed = append(failed, t.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d target(s) failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// tableThresholds mark a table as large enough that blocking DDL on it
+// deserves a warning. Size uses PostgreSQL size units, e.g. 1GB.
+type tableThresholds struct {
+	Rows int64
+	Size string
+}
+
+type tableStats struct {
+	Name   string
+	Rows   int64
+	Bytes  int64
+	Pretty string
+}
+
+// warnLargeTables warns about pending migrations running blocking DDL on
+// tables above the thresholds, using the planner statistics in pg_class.
+func (r *runner) warnLargeTables(ctx context.Context, pending []migrationInfo, res *runResult) error {
+	limits := r.opts.LargeTables
+	if limits.Rows <= 0 && limits.Size == "" {
+		return nil
+	}
+
+	var limitBytes int64
+	if limits.Size != "" {
+		if err := r.db.QueryRowContext(ctx, "SELECT pg_size_bytes($1)", limits.Size).Scan(&limitBytes); err != nil {
+			return fmt.Errorf("invalid large table size %q: %w", limits.Size, err)
+		}
+	}
+
+	stats := make(map[string]*tableStats)
+	for _, mi := range pending {
+		body, _, err := readUp(r.src, mi.Version)
+		if err != nil {
+			return err
+		}
+		for _, op := range blockingOperations(body) {
+			st, ok := stats[op.Table]
+			if !ok {
+				if st, err = r.tableStats(ctx, op.Table); err != nil {
+					res.warn("failed to read statistics of table %s: %v", op.Table, err)
+				}
+				stats[op.Table] = st
+			}
+			if st == nil {
+				continue
+			}
+			if limits.Rows > 0 && st.Rows >= limits.Rows || limitBytes > 0 && st.Bytes >= limitBytes {
+				res.warn("migration %d runs %s on %s (~%d rows, %s), which blocks writes to it until finished",
+					mi.Version, op.Kind, st.Name, st.Rows, st.Pretty)
+			}
+		}
+	}
+	return nil
+}
+
+// tableStats returns nil when the table does not exist yet. Unqualified
+// names are looked up in the migrated schema first.
+func (r *runner) tableStats(ctx context.Context, table string) (*tableStats, error) {
+	qualified := table
+	if !strings.Contains(table, ".") {
+		qualified = pq.QuoteIdentifier(r.schema) + "." + table
+	}
+
+	st := &tableStats{}
+	err := r.db.QueryRowContext(ctx, `SELECT c.oid::regclass::text, GREATEST(c.reltuples, 0)::bigint,
+		pg_total_relation_size(c.oid), pg_size_pretty(pg_total_relation_size(c.oid))
+		FROM pg_class c WHERE c.oid = COALESCE(to_regclass($1), to_regclass($2))`,
+		qualified, table).Scan(&st.Name, &st.Rows, &st.Bytes, &st.Pretty)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const tablespaceDirective = "-- tablespace:"
+
+// parseTablespace returns the tablespace named by a "-- tablespace:"
+// directive in the migration, or "".
+func parseTablespace(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, tablespaceDirective) {
+			return strings.TrimSpace(strings.TrimPrefix(line, tablespaceDirective))
+		}
+	}
+	return ""
+}
+
+// withTablespace runs fn with default_tablespace set to the tablespace the
+// migration asks for, so the tables and indexes it creates land there. The
+// setting is reset afterwards to the session default from
+// DB_DEFAULT_TABLESPACE.
+func (r *runner) withTablespace(ctx context.Context, body string, fn func() error) error {
+	tablespace := parseTablespace(body)
+	if tablespace == "" {
+		return fn()
+	}
+
+	if _, err := r.conn.ExecContext(ctx, "SET default_tablespace = "+pq.QuoteIdentifier(tablespace)); err != nil {
+		return err
+	}
+	err := fn()
+	if _, resetErr := r.conn.ExecContext(context.WithoutCancel(ctx), "RESET default_tablespace"); resetErr != nil && err == nil {
+		err = resetErr
+	}
+	return err
+}
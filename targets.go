@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+type target struct {
+	Config
+	Schema   string
+	Override targetOverride
+}
+
+func (t target) String() string {
+	if t.SRV != "" {
+		return fmt.Sprintf("%s/%s/%s", t.SRV, t.DBName, t.Schema)
+	}
+	return fmt.Sprintf("%s:%s/%s/%s", t.Host, t.Port, t.DBName, t.Schema)
+}
+
+// server identifies the database server for the per-host limit.
+func (t target) server() string {
+	if t.SRV != "" {
+		return t.SRV
+	}
+	return t.Host
+}
+
+// loadTargets reads one target per line. A line is either a bare schema name
+// or a list of key=value pairs (host, port, srv, user, password, dbname, sslmode,
+// target_session_attrs, default_tablespace, schema); anything not set falls back to the environment configuration and
+// the -schema flag.
+func loadTargets(path string, defaults *Config, defaultSchema string) ([]target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []target
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		t := target{Config: *defaults, Schema: defaultSchema}
+		for _, field := range strings.Fields(text) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				t.Schema = field
+				continue
+			}
+			switch key {
+			case "host":
+				t.Host = value
+			case "port":
+				t.Port = value
+			case "user":
+				t.User = value
+			case "password":
+				t.Password = value
+			case "dbname":
+				t.DBName = value
+			case "sslmode":
+				t.SSLMode = value
+			case "srv":
+				t.SRV = value
+			case "target_session_attrs":
+				t.TargetSessionAttrs = value
+			case "default_tablespace":
+				t.DefaultTablespace = value
+			case "schema":
+				t.Schema = value
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown target option %q", path, line, key)
+			}
+		}
+
+		if t.Schema == "" {
+			return nil, fmt.Errorf("%s:%d: schema is not set", path, line)
+		}
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if seen[t.String()] {
+			return nil, fmt.Errorf("%s:%d: duplicate target %s", path, line, t)
+		}
+		seen[t.String()] = true
+		targets = append(targets, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets defined", path)
+	}
+
+	return targets, nil
+}
+
+func migrateTarget(ctx context.Context, t target, sourceURL string, src source.Driver, command string, steps int, opts runOptions) (*runResult, error) {
+	var sess *session
+	err := opts.Retry.do(ctx, "connect to "+t.String(), func() (err error) {
+		sess, err = connect(ctx, &t.Config, t.Schema, sourceURL)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts.MaxVersion = t.Override.MaxVersion
+	r := newRunner(sess, src, t.Schema, opts, t.String())
+	r.redial = func(ctx context.Context) (*session, error) {
+		return connect(ctx, &t.Config, t.Schema, sourceURL)
+	}
+	defer r.close()
+	var res *runResult
+	switch command {
+	case "up":
+		res, err = r.up(ctx, steps)
+	case "down":
+		res, err = r.down(ctx, steps)
+	}
+	return res, ignoreNoChange(err)
+}
+
+type batchOptions struct {
+	Parallel   int
+	MaxPerHost int
+	// BatchSize splits the run into waves of that many targets separated
+	// by BatchPause; a wave with failures stops the run.
+	BatchSize  int
+	BatchPause time.Duration
+	State      *stateStore
+	BatchID    string
+	// Preflight checks the connection to every target before the first
+	// one is migrated.
+	Preflight bool
+}
+
+// targetQueue hands out targets in file order to a pool of workers while
+// keeping at most maxPerHost of them running against the same host.
+type targetQueue struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	pending    []target
+	running    map[string]int
+	maxPerHost int
+}
+
+func newTargetQueue(targets []target, maxPerHost int) *targetQueue {
+	q := &targetQueue{
+		pending:    targets,
+		running:    make(map[string]int),
+		maxPerHost: maxPerHost,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *targetQueue) next() (target, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) > 0 {
+		for i, t := range q.pending {
+			if q.maxPerHost > 0 && q.running[t.server()] >= q.maxPerHost {
+				continue
+			}
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			q.running[t.server()]++
+			return t, true
+		}
+		q.cond.Wait()
+	}
+	return target{}, false
+}
+
+func (q *targetQueue) done(t target) {
+	q.mu.Lock()
+	q.running[t.server()]--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func runTargets(ctx context.Context, targets []target, sourceURL string, src source.Driver, command string, steps int, run runOptions, opts batchOptions) error {
+	done := make(map[string]bool)
+	if opts.State != nil {
+		var err error
+		if done, err = opts.State.completed(ctx, opts.BatchID); err != nil {
+			return err
+		}
+	}
+
+	var queued []target
+	var excluded, frozen []string
+	skipped := 0
+	for _, t := range targets {
+		switch {
+		case t.Override.Frozen:
+			frozen = append(frozen, fmt.Sprintf("%s (%s)", t, t.Override.describe()))
+		case t.Override.Exclude:
+			excluded = append(excluded, fmt.Sprintf("%s (%s)", t, t.Override.describe()))
+		case done[t.String()]:
+			skipped++
+		default:
+			queued = append(queued, t)
+		}
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	if opts.Preflight && len(queued) > 0 {
+		if err := preflight(ctx, queued, parallel, opts.MaxPerHost, run.Retry); err != nil {
+			return err
+		}
+	}
+
+	var waves [][]target
+	if opts.BatchSize > 0 {
+		for len(queued) > opts.BatchSize {
+			waves = append(waves, queued[:opts.BatchSize])
+			queued = queued[opts.BatchSize:]
+		}
+	}
+	if len(queued) > 0 {
+		waves = append(waves, queued)
+	}
+
+	var (
+		mu         sync.Mutex
+		completed  int
+		failed     []string
+		notStarted int
+		stateErr   error
+	)
+	for i, wave := range waves {
+		if len(waves) > 1 {
+			log.Printf("Starting wave %d/%d (%d targets)", i+1, len(waves), len(wave))
+		}
+		failedBefore := len(failed)
+
+		var wg sync.WaitGroup
+		queue := newTargetQueue(wave, opts.MaxPerHost)
+		for range parallel {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					t, ok := queue.next()
+					if !ok {
+						return
+					}
+
+					if ctx.Err() != nil {
+						queue.done(t)
+						mu.Lock()
+						notStarted++
+						mu.Unlock()
+						continue
+					}
+
+					name := t.String()
+					log.Printf("[%s] Running %s", name, command)
+					res, err := migrateTarget(ctx, t, sourceURL, src, command, steps, run)
+					if err == nil && opts.State != nil {
+						if markErr := opts.State.markCompleted(context.WithoutCancel(ctx), opts.BatchID, name); markErr != nil {
+							mu.Lock()
+							stateErr = markErr
+							mu.Unlock()
+						}
+					}
+					queue.done(t)
+
+					mu.Lock()
+					if err != nil {
+						log.Printf("[%s] Migration failed: %v", name, err)
+						failed = append(failed, name)
+					} else {
+						log.Printf("[%s] Done: %s", name, res)
+						completed++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if i == len(waves)-1 {
+			break
+		}
+		remaining := 0
+		for _, rest := range waves[i+1:] {
+			remaining += len(rest)
+		}
+		if len(failed) > failedBefore || stateErr != nil {
+			log.Printf("Wave %d/%d had failures, not starting the remaining %d targets", i+1, len(waves), remaining)
+			notStarted += remaining
+			break
+		}
+		if opts.BatchPause > 0 {
+			log.Printf("Wave %d/%d done, pausing %s before the next wave", i+1, len(waves), opts.BatchPause)
+			select {
+			case <-time.After(opts.BatchPause):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			log.Printf("Run cancelled, not starting the remaining %d targets", remaining)
+			notStarted += remaining
+			break
+		}
+	}
+
+	log.Printf("Targets: %d total, %d completed, %d skipped as already completed, %d excluded, %d frozen, %d failed, %d not started",
+		len(targets), completed, skipped, len(excluded), len(frozen), len(failed), notStarted)
+	for _, name := range excluded {
+		log.Printf("Excluded: %s", name)
+	}
+	for _, name := range frozen {
+		log.Printf("Frozen: %s", name)
+	}
+	if stateErr != nil {
+		return stateErr
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d target(s) failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	if notStarted > 0 {
+		return fmt.Errorf("%d target(s) not started", notStarted)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var traceparentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// parseTraceparent validates a W3C Trace Context traceparent header, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", so that the run
+// can be linked to the trace of the deployment that started it.
+func parseTraceparent(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	match := traceparentPattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("invalid traceparent %q: expected version-traceid-parentid-flags", value)
+	}
+	switch {
+	case match[1] == "ff":
+		return "", fmt.Errorf("invalid traceparent %q: version ff is not allowed", value)
+	case strings.Trim(match[2], "0") == "":
+		return "", fmt.Errorf("invalid traceparent %q: trace id is all zeros", value)
+	case strings.Trim(match[3], "0") == "":
+		return "", fmt.Errorf("invalid traceparent %q: parent id is all zeros", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseTraceparent(t *testing.T) {
+	valid := map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		" 00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-00 ": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	}
+	for input, want := range valid {
+		got, err := parseTraceparent(input)
+		if err != nil {
+			t.Errorf("parseTraceparent(%q): %v", input, err)
+		} else if got != want {
+			t.Errorf("parseTraceparent(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	}
+	for _, input := range invalid {
+		if got, err := parseTraceparent(input); err == nil {
+			t.Errorf("parseTraceparent(%q) = %q, want an error", input, got)
+		}
+	}
+}